@@ -0,0 +1,282 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/parquet-go/parquet-go"
+)
+
+type Format string
+
+const (
+	FormatNDJSON  Format = "ndjson"
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatNDJSON, FormatCSV, FormatParquet:
+		return f, nil
+	case "jsonl":
+		return FormatNDJSON, nil
+	}
+	return "", fmt.Errorf("unknown format %q, has to be one of ndjson, csv, parquet", s)
+}
+
+// FormatFromFilename guesses archive format by file extension
+func FormatFromFilename(filename string) (Format, error) {
+	ext := strings.TrimPrefix(filepath.Ext(filename), ".")
+	if ext == "" {
+		return "", fmt.Errorf("can't guess format of %q", filename)
+	}
+	return ParseFormat(ext)
+}
+
+type Writer interface {
+	Write(feed.Article) error
+	Close() error
+}
+
+type Reader interface {
+	// Read returns io.EOF when there are no more articles
+	Read() (feed.Article, error)
+}
+
+// NewWriter returns a writer encoding articles to w in given format.
+// Raw feed items are only written if withItems is set.
+// Close has to be called to flush buffered data; it doesn't close w.
+func NewWriter(w io.Writer, format Format, withItems bool) (Writer, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w), withItems: withItems}, nil
+	case FormatCSV:
+		return newCSVWriter(w, withItems)
+	case FormatParquet:
+		return &parquetWriter{
+			w:         parquet.NewGenericWriter[parquetRecord](w, parquet.Compression(&parquet.Zstd)),
+			withItems: withItems,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatParquet:
+		readerAt, ok := r.(io.ReaderAt)
+		if !ok {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			readerAt = bytes.NewReader(data)
+		}
+		return &parquetReader{r: parquet.NewGenericReader[parquetRecord](readerAt)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type ndjsonRecord struct {
+	Resource    string          `json:"resource"`
+	Url         string          `json:"url"`
+	Title       string          `json:"title"`
+	Published   time.Time       `json:"published"`
+	Description string          `json:"description"`
+	FeedItem    json.RawMessage `json:"feed_item,omitempty"`
+}
+
+type ndjsonWriter struct {
+	enc       *json.Encoder
+	withItems bool
+}
+
+func (w *ndjsonWriter) Write(a feed.Article) error {
+	record := ndjsonRecord{
+		Resource:    a.Resource,
+		Url:         a.Url,
+		Title:       a.Title,
+		Published:   a.Published,
+		Description: a.Description,
+	}
+	if w.withItems && a.ItemJSON != "" {
+		record.FeedItem = json.RawMessage(a.ItemJSON)
+	}
+	return w.enc.Encode(record)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+type ndjsonReader struct {
+	dec *json.Decoder
+}
+
+func (r *ndjsonReader) Read() (feed.Article, error) {
+	var record ndjsonRecord
+	if err := r.dec.Decode(&record); err != nil {
+		return feed.Article{}, err
+	}
+	return feed.Article{
+		Resource:    record.Resource,
+		Url:         record.Url,
+		Title:       record.Title,
+		Published:   record.Published,
+		Description: record.Description,
+		ItemJSON:    string(record.FeedItem),
+	}, nil
+}
+
+var csvHeader = []string{"resource", "url", "title", "published", "description", "feed_item"}
+
+type csvWriter struct {
+	w         *csv.Writer
+	withItems bool
+}
+
+func newCSVWriter(w io.Writer, withItems bool) (*csvWriter, error) {
+	cw := csvWriter{w: csv.NewWriter(w), withItems: withItems}
+	header := csvHeader
+	if !withItems {
+		header = header[:len(header)-1]
+	}
+	return &cw, cw.w.Write(header)
+}
+
+func (w *csvWriter) Write(a feed.Article) error {
+	record := []string{a.Resource, a.Url, a.Title, a.Published.Format(time.RFC3339Nano), a.Description}
+	if w.withItems {
+		record = append(record, a.ItemJSON)
+	}
+	return w.w.Write(record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csvReader{r: csv.NewReader(r), columns: make(map[string]int)}
+	cr.r.FieldsPerRecord = -1
+
+	header, err := cr.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range header {
+		cr.columns[name] = i
+	}
+	for _, name := range csvHeader[:len(csvHeader)-1] {
+		if _, ok := cr.columns[name]; !ok {
+			return nil, fmt.Errorf("csv header doesn't have %q column", name)
+		}
+	}
+
+	return &cr, nil
+}
+
+func (r *csvReader) field(record []string, name string) string {
+	i, ok := r.columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
+func (r *csvReader) Read() (feed.Article, error) {
+	record, err := r.r.Read()
+	if err != nil {
+		return feed.Article{}, err
+	}
+
+	published, err := time.Parse(time.RFC3339Nano, r.field(record, "published"))
+	if err != nil {
+		return feed.Article{}, err
+	}
+
+	return feed.Article{
+		Resource:    r.field(record, "resource"),
+		Url:         r.field(record, "url"),
+		Title:       r.field(record, "title"),
+		Published:   published,
+		Description: r.field(record, "description"),
+		ItemJSON:    r.field(record, "feed_item"),
+	}, nil
+}
+
+type parquetRecord struct {
+	Resource    string    `parquet:"resource,dict"`
+	Url         string    `parquet:"url"`
+	Title       string    `parquet:"title"`
+	Published   time.Time `parquet:"published,timestamp"`
+	Description string    `parquet:"description"`
+	FeedItem    string    `parquet:"feed_item,optional"`
+}
+
+type parquetWriter struct {
+	w         *parquet.GenericWriter[parquetRecord]
+	withItems bool
+}
+
+func (w *parquetWriter) Write(a feed.Article) error {
+	record := parquetRecord{
+		Resource:    a.Resource,
+		Url:         a.Url,
+		Title:       a.Title,
+		Published:   a.Published,
+		Description: a.Description,
+	}
+	if w.withItems {
+		record.FeedItem = a.ItemJSON
+	}
+	_, err := w.w.Write([]parquetRecord{record})
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	return w.w.Close()
+}
+
+type parquetReader struct {
+	r *parquet.GenericReader[parquetRecord]
+}
+
+func (r *parquetReader) Read() (feed.Article, error) {
+	records := make([]parquetRecord, 1)
+	n, err := r.r.Read(records)
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return feed.Article{}, err
+	}
+
+	record := records[0]
+	return feed.Article{
+		Resource:    record.Resource,
+		Url:         record.Url,
+		Title:       record.Title,
+		Published:   record.Published,
+		Description: record.Description,
+		ItemJSON:    record.FeedItem,
+	}, nil
+}
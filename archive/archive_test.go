@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("CSV")
+	assert.Nil(t, err)
+	assert.Equal(t, FormatCSV, f)
+
+	f, err = FormatFromFilename("/tmp/export.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, FormatNDJSON, f)
+
+	_, err = FormatFromFilename("export")
+	assert.NotNil(t, err)
+
+	_, err = ParseFormat("xml")
+	assert.NotNil(t, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	published := time.Date(2023, 7, 14, 13, 0, 0, 0, time.UTC)
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: published, Description: "description, with comma", ItemJSON: `{"item":1}`},
+		{Resource: "resource2", Url: "google.com", Title: "title \"2\"", Published: published.Add(time.Hour), Description: "", ItemJSON: `{"item":2}`},
+	}
+
+	for _, format := range []Format{FormatNDJSON, FormatCSV, FormatParquet} {
+		for _, withItems := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s with items %v", format, withItems), func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := NewWriter(&buf, format, withItems)
+				assert.Nil(t, err)
+
+				for _, a := range articles {
+					assert.Nil(t, w.Write(a))
+				}
+				assert.Nil(t, w.Close())
+
+				r, err := NewReader(&buf, format)
+				assert.Nil(t, err)
+
+				for _, expected := range articles {
+					a, err := r.Read()
+					assert.Nil(t, err)
+					assert.Equal(t, expected.Resource, a.Resource)
+					assert.Equal(t, expected.Url, a.Url)
+					assert.Equal(t, expected.Title, a.Title)
+					assert.Equal(t, expected.Description, a.Description)
+					assert.True(t, expected.Published.Equal(a.Published))
+					if withItems {
+						assert.Equal(t, expected.ItemJSON, a.ItemJSON)
+					} else {
+						assert.Equal(t, "", a.ItemJSON)
+					}
+				}
+
+				_, err = r.Read()
+				assert.Equal(t, io.EOF, err)
+			})
+		}
+	}
+}
@@ -2,14 +2,17 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/comfyprog/allnews/config"
 	"github.com/comfyprog/allnews/feed"
+	"github.com/comfyprog/allnews/server"
 	"github.com/comfyprog/allnews/storage"
 	"github.com/spf13/cobra"
 )
@@ -24,73 +27,245 @@ func makeNamesMap(names []string) map[string]struct{} {
 
 type dryRunner struct{}
 
-func (*dryRunner) SaveArticles(ctx context.Context, articles []feed.Article) error {
+func (*dryRunner) SaveArticles(ctx context.Context, articles []feed.Article) (feed.SaveResult, error) {
 	for _, a := range articles {
 		fmt.Printf("%s\n", a.String())
 	}
-	return nil
+	return feed.SaveResult{Inserted: len(articles)}, nil
 }
 
 func handleGracefulShutdown(f context.CancelFunc) {
-	exit := make(chan os.Signal)
+	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
 	<-exit
 	f()
 }
 
-func collect(appConfig config.Config, names []string, dryRun bool, continuous bool) {
-	filterNames := len(names) > 0
-	namesMap := makeNamesMap(names)
-
-	feedGroups := make(map[string][]config.SourceConfig)
-	for _, source := range appConfig.Sources {
-		if filterNames {
-			if _, ok := namesMap[source.Name]; !ok {
-				continue
+// filterSources returns sources with given names, or all of them if no names are given
+func filterSources(sources []config.SourceConfig, names []string) []config.SourceConfig {
+	if len(names) == 0 {
+		return sources
+	}
 
-			}
+	namesMap := makeNamesMap(names)
+	filtered := make([]config.SourceConfig, 0, len(names))
+	for _, source := range sources {
+		if _, ok := namesMap[source.Name]; ok {
+			filtered = append(filtered, source)
 		}
-		feedGroups[source.Name] = append(feedGroups[source.Name], source)
 	}
+	return filtered
+}
 
+func collect(cmd *cobra.Command, appConfig config.Config, names []string, dryRun bool, continuous bool) {
 	var articleStorage feed.ArticleSaver
+	var db *storage.PostgresStorage
 
 	if dryRun {
 		articleStorage = &dryRunner{}
 	} else {
 		var err error
-		articleStorage, err = storage.NewPostgresStorage(appConfig.DbConnString)
+		db, err = storage.NewPostgresStorage(appConfig.DbConnString, storage.WithUpdateTracking(appConfig.TrackUpdates))
 		if err != nil {
 			log.Fatal(err)
 		}
+		articleStorage = db
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	go handleGracefulShutdown(cancel)
 
-	feed.ProcessFeeds(ctx, feedGroups, articleStorage, continuous)
+	holder := config.NewHolder(appConfig)
 
+	collectorOptions := []feed.CollectorOption{}
+	leasesReleased := make(chan struct{})
+	if db != nil && continuous {
+		leaser := startSourceLeaser(ctx, db, leasesReleased)
+		collectorOptions = append(collectorOptions, feed.WithLeaser(leaser))
+		// hubs deliver content to the serve command, this process only subscribes
+		if appConfig.WebSub.Enabled {
+			collectorOptions = append(collectorOptions, feed.WithWebSub(feed.NewWebSub(db, appConfig.WebSub)))
+		}
+	} else {
+		close(leasesReleased)
+	}
+
+	collector := feed.NewCollector(articleStorage, collectorOptions...)
+	applySources := func(c config.Config) {
+		if continuous {
+			collector.Apply(ctx, filterSources(c.Sources, names))
+		}
+	}
+
+	// without database sources come from config file only
+	reload := func(c config.Config) {
+		holder.Set(c)
+		applySources(c)
+	}
+
+	if db != nil {
+		sources, err := newSourceSync(ctx, db, holder, applySources)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reload = sources.reload(ctx)
+		if continuous {
+			go sources.run(ctx)
+		}
+	} else {
+		applySources(appConfig)
+	}
+
+	if !continuous {
+		if db != nil {
+			if _, err := db.EnsurePartitions(ctx, time.Now(), partitionsAhead); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		}
+
+		feedGroups := make(map[string][]config.SourceConfig)
+		for _, source := range filterSources(holder.Get().Sources, names) {
+			feedGroups[source.Name] = append(feedGroups[source.Name], source)
+		}
+		feed.ProcessFeeds(ctx, feedGroups, articleStorage, false)
+		return
+	}
+
+	if db != nil {
+		go runPeriodicPartitioning(ctx, db)
+		go runPeriodicPrune(ctx, db, holder)
+	}
+
+	go watchConfig(ctx, cmd, appConfig, reload)
+
+	collector.Wait(ctx)
+	<-leasesReleased
 }
 
-func makeCollectCmd(appConfig config.Config) *cobra.Command {
+// collectOnce fetches named sources immediately, bypassing schedule, leases and job queue
+func collectOnce(appConfig config.Config, names []string, dryRun bool) error {
+	if len(names) == 0 {
+		return errors.New("--once needs at least one --name")
+	}
+
+	ctx := context.Background()
+	var articleStorage feed.ArticleSaver
+	sources := make([]config.SourceConfig, 0, len(names))
+
+	if dryRun {
+		articleStorage = &dryRunner{}
+		sources = filterSources(appConfig.Sources, names)
+		if len(sources) != len(names) {
+			return fmt.Errorf("some of the sources %v are not in config file", names)
+		}
+	} else {
+		db, err := storage.NewPostgresStorage(appConfig.DbConnString, storage.WithUpdateTracking(appConfig.TrackUpdates))
+		if err != nil {
+			return err
+		}
+		// sources aren't synced, since a trimmed config file would remove the ones it lacks
+		for _, name := range names {
+			source, err := db.GetSource(ctx, name)
+			if errors.Is(err, server.ErrNotFound) {
+				if fromFile := filterSources(appConfig.Sources, []string{name}); len(fromFile) > 0 {
+					sources = append(sources, fromFile[0])
+					continue
+				}
+			}
+			if err != nil {
+				return err
+			}
+			sources = append(sources, source.SourceConfig)
+		}
+		articleStorage = db
+	}
+
+	failed := 0
+	for _, source := range sources {
+		result := feed.FetchSource(ctx, source, articleStorage)
+		if result.Err != nil {
+			failed++
+			fmt.Printf("%s: error: %v\n", source.Name, result.Err)
+			continue
+		}
+		fmt.Printf("%s: ok, %d items seen, %s\n", source.Name, result.ItemsSeen, result.Saved)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sources failed", failed, len(sources))
+	}
+	return nil
+}
+
+// instanceName identifies this process among collectors sharing the database
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// leases of a collector that died are taken over by others after this time
+const sourceLeaseTTL = time.Second * 30
+
+// startSourceLeaser starts renewing source leases of this process until ctx is done.
+// released is closed after the leases are given up on shutdown.
+func startSourceLeaser(ctx context.Context, db *storage.PostgresStorage, released chan struct{}) *storage.SourceLeaser {
+	leaser := db.NewSourceLeaser(instanceName(), sourceLeaseTTL)
+	log.Printf("Collecting as %s", leaser.Owner())
+
+	go func() {
+		defer close(released)
+		leaser.Run(ctx)
+	}()
+	return leaser
+}
+
+func makeCollectCmd(appConfig *config.Config) *cobra.Command {
 	var (
 		continuous bool
 		dryRun     bool
 		names      []string
+		worker     bool
+		scheduler  bool
+		once       bool
 	)
 
 	cmd := &cobra.Command{
-		Use:   "collect",
-		Short: "collects feeds",
-		Long:  "Collects feeds defined in config file and stores them in the database",
-		Run: func(cmd *cobra.Command, args []string) {
-			collect(appConfig, names, dryRun, continuous)
+		Use:     "collect",
+		Short:   "collects feeds",
+		Long:    "Collects feeds defined in config file and stores them in the database. In continuous mode sources are reloaded on SIGHUP or when the config file changes",
+		PreRunE: requireValidConfig(appConfig),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if once {
+				return collectOnce(*appConfig, names, dryRun)
+			}
+
+			if worker || scheduler {
+				if dryRun {
+					return errors.New("--dry-run can't be used with --worker or --scheduler")
+				}
+				collectFromQueue(cmd, *appConfig, names, worker, scheduler)
+				return nil
+			}
+
+			collect(cmd, *appConfig, names, dryRun, continuous)
+			return nil
 		},
 	}
 
 	cmd.PersistentFlags().BoolVarP(&continuous, "continuous", "c", false, "collect feed indefinitely with interval specified in the config file")
 	cmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print feed to stdout instead of saving it to the database")
 	cmd.PersistentFlags().StringArrayVar(&names, "name", []string{}, "name of the feed to process (can be multiple)")
+	cmd.PersistentFlags().BoolVar(&worker, "worker", false, "run fetch jobs from the queue")
+	cmd.PersistentFlags().BoolVar(&scheduler, "scheduler", false, "add jobs to the queue when sources are due to be fetched")
+	cmd.PersistentFlags().BoolVar(&once, "once", false, "fetch sources given with --name right away, even if disabled, and print the results")
+	cmd.MarkFlagsMutuallyExclusive("once", "continuous")
+	cmd.MarkFlagsMutuallyExclusive("once", "worker")
+	cmd.MarkFlagsMutuallyExclusive("once", "scheduler")
+	// workers run whatever jobs are queued, only the scheduler decides which sources get them
+	cmd.MarkFlagsMutuallyExclusive("name", "worker")
 	return cmd
 }
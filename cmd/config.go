@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var connStringPasswordRe = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// maskConnString hides password in both url and key=value forms of postgres connection string
+func maskConnString(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return connStringPasswordRe.ReplaceAllString(connStr, "${1}xxxxx")
+}
+
+// requireValidConfig is used as PreRunE of commands that can't work with invalid config
+func requireValidConfig(appConfig *config.Config) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		return appConfig.Validate()
+	}
+}
+
+func makeConfigCmd(appConfig *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect configuration",
+	}
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Validate config file and print effective configuration",
+		Long:  "Validates config file, reports all problems found and prints configuration with defaults applied",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resolved := *appConfig
+			resolved.DbConnString = maskConnString(resolved.DbConnString)
+
+			out, err := yaml.Marshal(resolved)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(out))
+
+			err = appConfig.Validate()
+			var errs config.ValidationErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					fmt.Fprintln(os.Stderr, e.String())
+				}
+				return fmt.Errorf("config has %d problems", len(errs))
+			}
+			return err
+		},
+	}
+
+	cmd.AddCommand(checkCmd)
+	return cmd
+}
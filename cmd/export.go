@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/comfyprog/allnews/archive"
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/comfyprog/allnews/server"
+	"github.com/comfyprog/allnews/storage"
+	"github.com/spf13/cobra"
+)
+
+const archiveDateFormat = "2006-01-02T15:04:05Z07:00"
+
+func getArchiveFormat(formatName string, filename string) (archive.Format, error) {
+	if formatName != "" {
+		return archive.ParseFormat(formatName)
+	}
+	if filename == "" || filename == "-" {
+		return archive.FormatNDJSON, nil
+	}
+	return archive.FormatFromFilename(filename)
+}
+
+func makeExportCmd(appConfig *config.Config) *cobra.Command {
+	var (
+		output    string
+		format    string
+		withItems bool
+		dateStart string
+		dateEnd   string
+		filter    string
+		names     []string
+		tags      []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export articles to a file",
+		Long:  "Writes articles matching given filters to a file in ndjson, csv or parquet format",
+		Run: func(cmd *cobra.Command, args []string) {
+			archiveFormat, err := getArchiveFormat(format, output)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			options := []server.GetArticleOption{server.WithLimit(0)}
+			if dateStart != "" {
+				t, err := time.Parse(archiveDateFormat, dateStart)
+				if err != nil {
+					log.Fatal(err)
+				}
+				options = append(options, server.WithDateStart(t))
+			}
+			if dateEnd != "" {
+				t, err := time.Parse(archiveDateFormat, dateEnd)
+				if err != nil {
+					log.Fatal(err)
+				}
+				options = append(options, server.WithDateEnd(t))
+			} else {
+				options = append(options, server.WithDateEnd(time.Now().Add(time.Hour*24*365*100)))
+			}
+			if filter != "" {
+				options = append(options, server.WithFilter(filter))
+			}
+
+			if len(tags) > 0 {
+				tagged, err := appConfig.GetResourcesWithTags(tags)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if len(names) > 0 {
+					namesMap := makeNamesMap(names)
+					names = []string{}
+					for _, name := range tagged {
+						if _, ok := namesMap[name]; ok {
+							names = append(names, name)
+						}
+					}
+				} else {
+					names = tagged
+				}
+				if len(names) == 0 {
+					log.Fatal("no resources match given tags")
+				}
+			}
+			if len(names) > 0 {
+				options = append(options, server.WithResourceNames(names))
+			}
+
+			var out io.Writer = os.Stdout
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer f.Close()
+				out = f
+			}
+
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			w, err := archive.NewWriter(out, archiveFormat, withItems)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			count := 0
+			err = db.StreamArticles(context.Background(), withItems, func(a feed.Article) error {
+				count++
+				return w.Write(a)
+			}, options...)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := w.Close(); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("exported %d articles", count)
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", "", "output file, stdout if not set")
+	cmd.PersistentFlags().StringVar(&format, "format", "", "output format: ndjson, csv or parquet (guessed from output file extension by default)")
+	cmd.PersistentFlags().BoolVar(&withItems, "with-items", false, "include raw feed items")
+	cmd.PersistentFlags().StringVar(&dateStart, "date-start", "", "export articles published after this date (RFC3339)")
+	cmd.PersistentFlags().StringVar(&dateEnd, "date-end", "", "export articles published before this date (RFC3339)")
+	cmd.PersistentFlags().StringVar(&filter, "filter", "", "export articles which titles contain this string")
+	cmd.PersistentFlags().StringArrayVar(&names, "name", []string{}, "name of the resource to export (can be multiple)")
+	cmd.PersistentFlags().StringArrayVar(&tags, "tag", []string{}, "export resources with this tag in 'tagCategory:tagValue' format (can be multiple)")
+	return cmd
+}
+
+func makeImportCmd(appConfig *config.Config) *cobra.Command {
+	var (
+		format    string
+		batchSize int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Import articles from a file",
+		Long:  "Loads articles from a file created by export command. Articles with already known urls are skipped",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if batchSize <= 0 {
+				log.Fatalf("batch-size has to be positive, got %d", batchSize)
+			}
+
+			filename := args[0]
+			archiveFormat, err := getArchiveFormat(format, filename)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var in io.Reader = os.Stdin
+			if filename != "-" {
+				f, err := os.Open(filename)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer f.Close()
+				in = f
+			}
+
+			r, err := archive.NewReader(in, archiveFormat)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ctx := context.Background()
+			batch := make([]feed.Article, 0, batchSize)
+			total := feed.SaveResult{}
+			for {
+				a, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				batch = append(batch, a)
+				if len(batch) < batchSize {
+					continue
+				}
+
+				result, err := db.SaveArticles(ctx, batch)
+				if err != nil {
+					log.Fatal(err)
+				}
+				total.Add(result)
+				batch = batch[:0]
+			}
+
+			if len(batch) > 0 {
+				result, err := db.SaveArticles(ctx, batch)
+				if err != nil {
+					log.Fatal(err)
+				}
+				total.Add(result)
+			}
+
+			for _, r := range total.Rejected {
+				log.Printf("rejected %s: %s", r.Article.Url, r.Reason)
+			}
+			log.Printf("imported articles: %s", total)
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&format, "format", "", "input format: ndjson, csv or parquet (guessed from file extension by default)")
+	cmd.PersistentFlags().IntVar(&batchSize, "batch-size", 500, "number of articles saved at once")
+	return cmd
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/comfyprog/allnews/storage"
+	"github.com/spf13/cobra"
+)
+
+// runScheduler enqueues jobs for due sources every queue.poll_interval until ctx is done
+func runScheduler(ctx context.Context, db *storage.PostgresStorage, holder *config.Holder, names []string) {
+	queueConfig := holder.Get().Queue
+	ticker := time.NewTicker(queueConfig.PollInterval)
+	defer ticker.Stop()
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		current := holder.Get()
+		added, err := db.EnqueueDueJobs(ctx, filterSources(current.Sources, names), current.Queue.MaxAttempts)
+		if err != nil {
+			log.Printf("Error: enqueuing jobs: %v", err)
+		} else if added > 0 {
+			log.Printf("Enqueued %d jobs", added)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cleanup.C:
+			if _, err := db.DeleteFinishedJobs(ctx, current.Queue.KeepDone); err != nil {
+				log.Printf("Error: deleting finished jobs: %v", err)
+			}
+		}
+	}
+}
+
+// collectFromQueue runs as a scheduler, a worker or both until interrupted
+func collectFromQueue(cmd *cobra.Command, appConfig config.Config, names []string, worker bool, scheduler bool) {
+	db, err := storage.NewPostgresStorage(appConfig.DbConnString, storage.WithUpdateTracking(appConfig.TrackUpdates))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go handleGracefulShutdown(cancel)
+
+	holder := config.NewHolder(appConfig)
+	sources, err := newSourceSync(ctx, db, holder, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go sources.run(ctx)
+	go watchConfig(ctx, cmd, appConfig, sources.reload(ctx))
+
+	done := make(chan struct{})
+	if scheduler {
+		go runPeriodicPartitioning(ctx, db)
+		go runPeriodicPrune(ctx, db, holder)
+		go func() {
+			runScheduler(ctx, db, holder, names)
+			done <- struct{}{}
+		}()
+	}
+
+	if worker {
+		queue := db.NewJobQueue(instanceName(), appConfig.Queue.VisibilityTimeout)
+		log.Printf("Running jobs as %s", queue.Worker())
+
+		lookup := func(name string) (config.SourceConfig, bool) {
+			for _, s := range holder.Get().Sources {
+				if s.Name == name {
+					return s, true
+				}
+			}
+			return config.SourceConfig{}, false
+		}
+		w := feed.NewWorker(queue, db, lookup, appConfig.Queue.Concurrency, appConfig.Queue.PollInterval)
+		go func() {
+			w.Run(ctx)
+			done <- struct{}{}
+		}()
+	}
+
+	if scheduler {
+		<-done
+	}
+	if worker {
+		<-done
+	}
+}
+
+func makeJobsCmd(appConfig *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "Inspect fetch job queue",
+		Long:  "Lists fetch jobs created by collect --scheduler and requeues failed ones",
+	}
+
+	var (
+		state string
+		limit uint64
+	)
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recent jobs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				return err
+			}
+
+			jobs, err := db.ListJobs(context.Background(), state, limit)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tSOURCE\tSTATE\tATTEMPTS\tRUN AT\tWORKER\tERROR")
+			for _, j := range jobs {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%d/%d\t%s\t%s\t%s\n", j.ID, j.Source, j.State,
+					j.Attempts, j.MaxAttempts, j.RunAt.Format(time.RFC3339), j.LockedBy, j.LastError)
+			}
+			return w.Flush()
+		},
+	}
+	listCmd.Flags().StringVar(&state, "state", "", fmt.Sprintf("show only jobs in this state (%s, %s, %s or %s)",
+		feed.JobPending, feed.JobRunning, feed.JobDone, feed.JobDead))
+	listCmd.Flags().Uint64Var(&limit, "limit", 50, "maximum number of jobs to show, 0 for all")
+
+	retryCmd := &cobra.Command{
+		Use:   "retry [ID...]",
+		Short: "Requeue dead jobs",
+		Long:  "Moves dead jobs with given ids, or all dead jobs, back to the queue with attempts reset",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids := make([]int64, 0, len(args))
+			for _, arg := range args {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil {
+					return fmt.Errorf("job id has to be an integer, got %q", arg)
+				}
+				ids = append(ids, id)
+			}
+
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				return err
+			}
+
+			n, err := db.RetryJobs(context.Background(), ids)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("requeued %d jobs\n", n)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(listCmd, retryCmd)
+	return cmd
+}
@@ -1,18 +1,128 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/comfyprog/allnews/config"
 	"github.com/comfyprog/allnews/storage"
 	"github.com/spf13/cobra"
 )
 
-func makeMigragteDbCmd(config config.Config) *cobra.Command {
-	return &cobra.Command{
+func migrateUp(config config.Config, to uint, allowDestructive bool) error {
+	m, err := storage.NewMigrator(config.DbConnString)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(to, allowDestructive); err != nil {
+		return err
+	}
+
+	db, err := storage.NewPostgresStorage(config.DbConnString)
+	if err != nil {
+		return err
+	}
+	_, err = db.EnsurePartitions(context.Background(), time.Now(), partitionsAhead)
+	return err
+}
+
+func makeMigragteDbCmd(config *config.Config) *cobra.Command {
+	var allowDestructive bool
+
+	cmd := &cobra.Command{
 		Use:   "migratedb",
 		Short: "Apply database migrations",
 		Long:  "migratedb command tries to setup the database to be usable with this version of allnews",
-		Run: func(cmd *cobra.Command, args []string) {
-			storage.Migrate(config.DbConnString)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrateUp(*config, 0, allowDestructive)
+		},
+	}
+	cmd.PersistentFlags().BoolVar(&allowDestructive, "allow-destructive", false, "allow migrations that remove data from non-empty tables")
+
+	var to uint
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations",
+		Long:  "Applies pending migrations, all of them or up to the version set with --to",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrateUp(*config, to, allowDestructive)
+		},
+	}
+	upCmd.Flags().UintVar(&to, "to", 0, "version to migrate to (latest by default)")
+
+	downCmd := &cobra.Command{
+		Use:   "down N",
+		Short: "Revert N last migrations",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("number of migrations has to be a positive integer, got %q", args[0])
+			}
+
+			m, err := storage.NewMigrator(config.DbConnString)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			return m.Down(n, allowDestructive)
+		},
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show current version and pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := storage.NewMigrator(config.DbConnString)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			status, err := m.Status()
+			if err != nil {
+				return err
+			}
+
+			dirty := ""
+			if status.Dirty {
+				dirty = " (dirty, fix the database and use force)"
+			}
+			fmt.Printf("current version: %d%s\n", status.Version, dirty)
+			fmt.Printf("applied: %v\n", status.Applied)
+			fmt.Printf("pending: %v\n", status.Pending)
+			return nil
 		},
 	}
+
+	forceCmd := &cobra.Command{
+		Use:   "force VERSION",
+		Short: "Set migration version without running migrations",
+		Long:  "Marks the database as being at VERSION and clears dirty state. Use it after fixing a failed migration by hand",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("version has to be an integer, got %q", args[0])
+			}
+
+			m, err := storage.NewMigrator(config.DbConnString)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			return m.Force(version)
+		},
+	}
+
+	cmd.AddCommand(upCmd, downCmd, statusCmd, forceCmd)
+	return cmd
 }
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/storage"
+	"github.com/spf13/cobra"
+)
+
+const partitionsAhead = 3
+
+// runPeriodicPartitioning makes sure that partitions for the upcoming months exist,
+// once on start and then daily until ctx is done
+func runPeriodicPartitioning(ctx context.Context, db *storage.PostgresStorage) {
+	ticker := time.NewTicker(time.Hour * 24)
+	defer ticker.Stop()
+
+	for {
+		if _, err := db.EnsurePartitions(ctx, time.Now(), partitionsAhead); err != nil {
+			log.Printf("Error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func makePartitionsCmd(appConfig *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "partitions",
+		Short: "Manage articles table partitions",
+		Long:  "Lists, creates and detaches monthly partitions of the articles table",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List partitions",
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			partitions, err := db.ListPartitions(context.Background())
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, p := range partitions {
+				fmt.Printf("%s\t%s\n", p.Name, p.MonthStart.Format("2006-01"))
+			}
+		},
+	}
+
+	var ahead int
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create partitions for current and upcoming months",
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			names, err := db.EnsurePartitions(context.Background(), time.Now(), ahead)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		},
+	}
+	createCmd.PersistentFlags().IntVar(&ahead, "ahead", partitionsAhead, "number of months to create partitions for in advance")
+
+	var (
+		before string
+		drop   bool
+	)
+	detachCmd := &cobra.Command{
+		Use:   "detach",
+		Short: "Detach partitions with old articles",
+		Long:  "Detaches partitions containing only articles published before given month. Detached partitions are kept as standalone tables unless --drop is set",
+		Run: func(cmd *cobra.Command, args []string) {
+			month, err := time.Parse("2006-01", before)
+			if err != nil {
+				log.Fatalf("--before has to be in YYYY-MM format: %v", err)
+			}
+
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			names, err := db.DetachPartitionsBefore(context.Background(), month, drop)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		},
+	}
+	detachCmd.PersistentFlags().StringVar(&before, "before", "", "detach partitions older than this month (YYYY-MM)")
+	detachCmd.PersistentFlags().BoolVar(&drop, "drop", false, "drop detached partitions instead of keeping them as archive tables")
+	detachCmd.MarkPersistentFlagRequired("before")
+
+	cmd.AddCommand(listCmd, createCmd, detachCmd)
+	return cmd
+}
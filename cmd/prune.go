@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/storage"
+	"github.com/spf13/cobra"
+)
+
+func pruneArticles(ctx context.Context, db *storage.PostgresStorage, appConfig config.Config, names []string, opts storage.PruneOptions) error {
+	if len(names) == 0 {
+		stats, err := db.GetArticleStats(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			names = append(names, s.Resource)
+		}
+	}
+
+	for _, name := range names {
+		policy := appConfig.RetentionFor(name)
+		if policy.IsEmpty() {
+			continue
+		}
+
+		result, err := db.PruneArticles(ctx, name, policy, opts)
+		if err != nil {
+			return fmt.Errorf("pruning %s: %w", name, err)
+		}
+
+		if result.Expired+result.OverLimit+result.ItemsDropped == 0 {
+			continue
+		}
+
+		verb := "pruned"
+		if opts.DryRun {
+			verb = "would prune"
+		}
+		log.Printf("%s %s: %d expired, %d over limit, %d feed items dropped",
+			verb, name, result.Expired, result.OverLimit, result.ItemsDropped)
+	}
+
+	return nil
+}
+
+// runPeriodicPrune applies retention policy every retention.interval until ctx is done.
+// It does nothing if the interval isn't set. Policies are taken from current config on every run.
+func runPeriodicPrune(ctx context.Context, db *storage.PostgresStorage, holder *config.Holder) {
+	interval := holder.Get().Retention.Interval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("prune loop terminating")
+			return
+		case <-ticker.C:
+			if err := pruneArticles(ctx, db, holder.Get(), nil, storage.PruneOptions{}); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		}
+	}
+}
+
+func makePruneCmd(appConfig *config.Config) *cobra.Command {
+	var (
+		dryRun    bool
+		batchSize int
+		names     []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Apply retention policy",
+		Long:  "Deletes articles and drops raw feed items according to retention settings in the config file",
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			opts := storage.PruneOptions{DryRun: dryRun, BatchSize: batchSize}
+			if err := pruneArticles(context.Background(), db, *appConfig, names, opts); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "only report what would be pruned")
+	cmd.PersistentFlags().IntVar(&batchSize, "batch-size", 1000, "number of rows deleted per statement")
+	cmd.PersistentFlags().StringArrayVar(&names, "name", []string{}, "name of the resource to prune (can be multiple)")
+	return cmd
+}
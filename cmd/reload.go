@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// editors often write files in several steps, so changes are applied after things calm down
+const reloadDebounce = time.Millisecond * 500
+
+// configWatch tracks files and include patterns of the current config
+type configWatch struct {
+	watcher *fsnotify.Watcher
+	dirs    map[string]struct{}
+	files   []string
+	globs   []string
+}
+
+// update starts watching directories of the files config consists of, and of the include patterns.
+// Directories are watched instead of files because files replaced by rename
+// (vim, kubernetes configmaps) stop being watched after the first change.
+func (w *configWatch) update(current config.Config) {
+	w.files = current.Files()
+	w.globs = current.IncludePatterns()
+
+	dirs := make([]string, 0, len(w.files)+len(w.globs))
+	for _, f := range w.files {
+		dirs = append(dirs, filepath.Dir(f))
+	}
+	for _, g := range w.globs {
+		dirs = append(dirs, filepath.Dir(g))
+	}
+
+	for _, dir := range dirs {
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+		// directories that don't exist yet, like an empty sources.d, are picked up on next reload
+		if err := w.watcher.Add(dir); err == nil {
+			w.dirs[dir] = struct{}{}
+		}
+	}
+}
+
+func (w *configWatch) matches(name string) bool {
+	name = filepath.Clean(name)
+	if slices.Contains(w.files, name) {
+		return true
+	}
+	for _, g := range w.globs {
+		if ok, _ := filepath.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchConfig rereads config on SIGHUP or when any of its files change and passes it to onChange.
+// Config that fails to load or validate is reported and ignored, so the old one stays in use.
+// It blocks until ctx is done.
+func watchConfig(ctx context.Context, cmd *cobra.Command, current config.Config, onChange func(config.Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var (
+		watch  *configWatch
+		events chan fsnotify.Event
+		errs   chan error
+	)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Error: can't watch config files, only SIGHUP will reload them: %v", err)
+	} else {
+		defer watcher.Close()
+		watch = &configWatch{watcher: watcher, dirs: make(map[string]struct{})}
+		watch.update(current)
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	reload := func() {
+		loaded, err := readConfig(cmd, current.Version)
+		if err == nil {
+			err = loaded.Validate()
+		}
+		if err != nil {
+			log.Printf("Error: config not reloaded: %v", err)
+			return
+		}
+		log.Printf("Config reloaded from %s", configFilename(cmd))
+		if watch != nil {
+			watch.update(loaded)
+		}
+		onChange(loaded)
+	}
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload()
+		case event := <-events:
+			if !event.Has(fsnotify.Chmod) && watch.matches(event.Name) {
+				debounce.Reset(reloadDebounce)
+			}
+		case err := <-errs:
+			log.Printf("Error: watching config files: %v", err)
+		case <-debounce.C:
+			reload()
+		}
+	}
+}
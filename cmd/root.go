@@ -2,37 +2,102 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/comfyprog/allnews/config"
 	"github.com/spf13/cobra"
 )
 
-func makeRootCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "allnews",
-		Short: "RSS feed aggregator",
-		Long:  "Allnews is an application that gathers user-defined RSS feeds and displays them as a single timeline",
+// configFilename returns path of the config file set with --config or ALLNEWS_CONFIG
+func configFilename(cmd *cobra.Command) string {
+	flags := cmd.Flags()
+
+	filename, _ := flags.GetString("config")
+	if !flags.Changed("config") {
+		if envFilename, ok := os.LookupEnv("ALLNEWS_CONFIG"); ok {
+			filename = envFilename
+		}
+	}
+	return filename
+}
+
+// readConfig reads config file and applies command line overrides,
+// which take precedence over both the file and environment variables
+func readConfig(cmd *cobra.Command, version string) (config.Config, error) {
+	flags := cmd.Flags()
+
+	loaded, err := config.Get(configFilename(cmd), version)
+	if err != nil {
+		return loaded, err
+	}
+
+	if flags.Changed("db") {
+		loaded.DbConnString, _ = flags.GetString("db")
 	}
+	if flags.Changed("listen") {
+		loaded.ListenAddr, _ = flags.GetString("listen")
+	}
+
+	return loaded, nil
+}
+
+func loadConfig(appConfig *config.Config, version string, cmd *cobra.Command) error {
+	loaded, err := readConfig(cmd, version)
+	if err != nil {
+		return err
+	}
+
+	*appConfig = loaded
+	return nil
+}
+
+func makeRootCmd(appConfig *config.Config, version string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "allnews",
+		Short:         "RSS feed aggregator",
+		Long:          "Allnews is an application that gathers user-defined RSS feeds and displays them as a single timeline",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Name() == "version" {
+				return nil
+			}
+			return loadConfig(appConfig, version, cmd)
+		},
+	}
+
+	cmd.PersistentFlags().String("config", "config.yml", "path to the config file (ALLNEWS_CONFIG)")
+	cmd.PersistentFlags().String("db", "", "database connection string, overrides config file and environment")
+	cmd.PersistentFlags().String("listen", "", "address to listen on, overrides config file and environment")
+	return cmd
 }
 
-func makeVersionCmd(config config.Config) *cobra.Command {
+func makeVersionCmd(version string) *cobra.Command {
 	return &cobra.Command{
 		Use:   "version",
 		Short: "Prints version",
 		Long:  "Prints program version",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("version: %s, %s\n", config.Version, runtime.Version())
+			fmt.Printf("version: %s, %s\n", version, runtime.Version())
 		},
 	}
 }
 
-func Execute(config config.Config) error {
+func Execute(version string) error {
+	config := &config.Config{}
 	migrateCmd := makeMigragteDbCmd(config)
 	collectCmd := makeCollectCmd(config)
 	serveCmd := makeServeCmd(config)
-	versionCmd := makeVersionCmd(config)
-	rootCmd := makeRootCmd()
-	rootCmd.AddCommand(migrateCmd, collectCmd, serveCmd, versionCmd)
+	versionCmd := makeVersionCmd(version)
+	pruneCmd := makePruneCmd(config)
+	partitionsCmd := makePartitionsCmd(config)
+	exportCmd := makeExportCmd(config)
+	importCmd := makeImportCmd(config)
+	configCmd := makeConfigCmd(config)
+	jobsCmd := makeJobsCmd(config)
+	usersCmd := makeUsersCmd(config)
+	rootCmd := makeRootCmd(config, version)
+	rootCmd.AddCommand(migrateCmd, collectCmd, serveCmd, versionCmd, pruneCmd, partitionsCmd, exportCmd, importCmd, configCmd, jobsCmd, usersCmd)
 	return rootCmd.Execute()
 }
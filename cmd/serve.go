@@ -1,26 +1,84 @@
 package cmd
 
 import (
+	"context"
 	"log"
 
-	"github.com/comfyprog/allnews/config"
+	appconfig "github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
 	"github.com/comfyprog/allnews/server"
 	"github.com/comfyprog/allnews/storage"
 	"github.com/spf13/cobra"
 )
 
-func makeServeCmd(config config.Config) *cobra.Command {
+func makeServeCmd(config *appconfig.Config) *cobra.Command {
 	var withCollect bool
 	cmd := &cobra.Command{
-		Use:   "serve",
-		Short: "Start http server",
-		Long:  "Starts http server on the address and port defined in the config file",
+		Use:     "serve",
+		Short:   "Start http server",
+		Long:    "Starts http server on the address and port defined in the config file",
+		PreRunE: requireValidConfig(config),
 		Run: func(cmd *cobra.Command, args []string) {
-			storage, err := storage.NewPostgresStorage(config.DbConnString)
+			broadcaster := server.NewBroadcaster(server.StreamHistorySize)
+			storage, err := storage.NewPostgresStorage(config.DbConnString,
+				storage.WithUpdateTracking(config.TrackUpdates), storage.WithSaveListener(broadcaster.Publish))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go handleGracefulShutdown(cancel)
+
+			holder := appconfig.NewHolder(*config)
+
+			// a nil *feed.WebSub in the interface wouldn't be nil either
+			var websub *feed.WebSub
+			var receiver server.WebSubReceiver
+			if config.WebSub.Enabled {
+				// holder keeps enabled sources, synced from the database
+				websub = feed.NewWebSub(storage, config.WebSub, feed.WithSources(func() []appconfig.SourceConfig {
+					return holder.Get().Sources
+				}))
+				receiver = websub
+			}
+
+			var collector *feed.Collector
+			var onSourcesChange func(appconfig.Config)
+			leasesReleased := make(chan struct{})
+			if withCollect {
+				leaser := startSourceLeaser(ctx, storage, leasesReleased)
+				collector = feed.NewCollector(storage, feed.WithLeaser(leaser), feed.WithWebSub(websub))
+				onSourcesChange = func(c appconfig.Config) {
+					collector.Apply(ctx, c.Sources)
+				}
+			}
+
+			sources, err := newSourceSync(ctx, storage, holder, onSourcesChange)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go sources.run(ctx)
+			go watchConfig(ctx, cmd, *config, sources.reload(ctx))
+			go runPeriodicPrune(ctx, storage, holder)
+			// articles saved by collectors running elsewhere
+			go storage.NewArticleListener(broadcaster.Publish).Run(ctx)
+
+			// a nil *feed.Collector in the interface wouldn't be nil
+			var status server.CollectorStatusGetter
+			if collector != nil {
+				go runPeriodicPartitioning(ctx, storage)
+				status = collector
+			}
+
+			err = server.Serve(ctx, storage, holder, status, broadcaster, receiver)
+			cancel()
+			if collector != nil {
+				collector.Wait(ctx)
+				<-leasesReleased
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
-			log.Fatal(server.Serve(storage, config))
 		},
 	}
 
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/storage"
+)
+
+// sources are polled so that changes made through api reach running collectors
+const sourcesPollInterval = time.Second * 30
+
+// sourceSync keeps sources of the config in holder equal to enabled sources from the database
+type sourceSync struct {
+	db       *storage.PostgresStorage
+	holder   *config.Holder
+	onChange func(config.Config)
+
+	mu         sync.Mutex
+	fileConfig config.Config
+}
+
+// newSourceSync copies sources from config file to the database and puts config
+// with sources from the database into holder
+func newSourceSync(ctx context.Context, db *storage.PostgresStorage, holder *config.Holder, onChange func(config.Config)) (*sourceSync, error) {
+	s := &sourceSync{db: db, holder: holder, onChange: onChange}
+	return s, s.setFileConfig(ctx, holder.Get())
+}
+
+// setFileConfig is called when config file is (re)loaded
+func (s *sourceSync) setFileConfig(ctx context.Context, fileConfig config.Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.db.SyncSources(ctx, fileConfig.Origin, fileConfig.Sources); err != nil {
+		return err
+	}
+	s.fileConfig = fileConfig
+	return s.refresh(ctx, true)
+}
+
+// refresh has to be called with s.mu held
+func (s *sourceSync) refresh(ctx context.Context, force bool) error {
+	dbSources, err := s.db.GetSources(ctx)
+	if err != nil {
+		return err
+	}
+
+	fileSources := make(map[string]config.SourceConfig, len(s.fileConfig.Sources))
+	for _, source := range s.fileConfig.Sources {
+		fileSources[source.Name] = source
+	}
+
+	sources := make([]config.SourceConfig, 0, len(dbSources))
+	for _, dbSource := range dbSources {
+		if !dbSource.Enabled {
+			continue
+		}
+		source := dbSource.SourceConfig
+		// settings that aren't stored in the database
+		if fileSource, ok := fileSources[source.Name]; ok && dbSource.FromConfig {
+			source.Retention = fileSource.Retention
+			source.File = fileSource.File
+		}
+		sources = append(sources, source)
+	}
+
+	if !force && sameSources(s.holder.Get().Sources, sources) {
+		return nil
+	}
+
+	updated := s.fileConfig
+	updated.Sources = sources
+	s.holder.Set(updated)
+	if s.onChange != nil {
+		s.onChange(updated)
+	}
+	return nil
+}
+
+func sameSources(a, b []config.SourceConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// run polls the database for changes made through api until ctx is done
+func (s *sourceSync) run(ctx context.Context) {
+	ticker := time.NewTicker(sourcesPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.refresh(ctx, false)
+			s.mu.Unlock()
+			if err != nil {
+				log.Printf("Error: refreshing sources: %v", err)
+			}
+		}
+	}
+}
+
+// reload is passed to watchConfig
+func (s *sourceSync) reload(ctx context.Context) func(config.Config) {
+	return func(fileConfig config.Config) {
+		if err := s.setFileConfig(ctx, fileConfig); err != nil {
+			log.Printf("Error: syncing sources: %v", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/server"
+	"github.com/comfyprog/allnews/storage"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// readPassword asks for the password twice without echo if stdin is a terminal,
+// otherwise the first line of stdin is used so that the command can be scripted
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(repeated) {
+		return "", errors.New("passwords don't match")
+	}
+	return string(password), nil
+}
+
+func makeUsersCmd(appConfig *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "users",
+		Short: "Manage user accounts",
+		Long:  "Creates accounts of users that log in to the site",
+	}
+
+	var admin bool
+	createCmd := &cobra.Command{
+		Use:   "create USERNAME",
+		Short: "Create a user",
+		Long:  "Creates a user with password read from the terminal or from the first line of stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			username := strings.TrimSpace(args[0])
+			if username == "" {
+				return errors.New("username can't be empty")
+			}
+
+			password, err := readPassword()
+			if err != nil {
+				return err
+			}
+			hash, err := server.HashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			db, err := storage.NewPostgresStorage(appConfig.DbConnString)
+			if err != nil {
+				return err
+			}
+
+			user, err := db.CreateUser(context.Background(), server.User{Username: username, PasswordHash: hash, Admin: admin})
+			if err != nil {
+				return err
+			}
+			role := "user"
+			if user.Admin {
+				role = "admin"
+			}
+			fmt.Printf("created %s %s\n", role, user.Username)
+			return nil
+		},
+	}
+	createCmd.Flags().BoolVar(&admin, "admin", false, "give the user admin rights")
+
+	cmd.AddCommand(createCmd)
+	return cmd
+}
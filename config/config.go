@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"time"
 
@@ -10,19 +12,271 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// RetentionPolicy describes how long articles are kept.
+// Zero values mean "no limit".
+type RetentionPolicy struct {
+	MaxAge        time.Duration `yaml:"max_age,omitempty"`
+	MaxArticles   int           `yaml:"max_articles,omitempty"`
+	DropItemAfter time.Duration `yaml:"drop_item_after,omitempty"`
+}
+
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.MaxAge == 0 && p.MaxArticles == 0 && p.DropItemAfter == 0
+}
+
+// RetentionConfig is the global retention policy plus the interval
+// at which long-running commands apply it
+type RetentionConfig struct {
+	RetentionPolicy `yaml:",inline"`
+	Interval        time.Duration `yaml:"interval,omitempty"`
+}
+
+const (
+	defaultTimeout      = time.Second * 30
+	defaultUpdatePeriod = time.Hour
+
+	defaultReadHeaderTimeout = time.Second * 10
+	defaultReadTimeout       = time.Second * 30
+	defaultWriteTimeout      = time.Second * 60
+	defaultIdleTimeout       = time.Second * 120
+	defaultShutdownTimeout   = time.Second * 10
+	defaultMaxHeaderBytes    = 1 << 20
+	defaultServerMode        = "release"
+	defaultRefreshInterval   = time.Minute
+
+	defaultQueueMaxAttempts       = 5
+	defaultQueueVisibilityTimeout = time.Minute * 5
+	defaultQueuePollInterval      = time.Second * 5
+	defaultQueueConcurrency       = 4
+	defaultQueueKeepDone          = time.Hour * 24
+
+	defaultWebSubLease          = time.Hour * 24 * 10
+	defaultWebSubFallbackUpdate = time.Hour * 6
+
+	defaultSessionTTL = time.Hour * 24 * 30
+)
+
+// DefaultsConfig holds values used for sources that don't set them
+type DefaultsConfig struct {
+	Timeout      time.Duration       `yaml:"timeout"`
+	UpdatePeriod time.Duration       `yaml:"update"`
+	Tags         map[string][]string `yaml:"tags,omitempty"`
+}
+
+// ServerConfig holds http server settings
+type ServerConfig struct {
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long requests in progress are waited for on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	MaxHeaderBytes  int           `yaml:"max_header_bytes"`
+	// TLSCert and TLSKey are paths to PEM files, https is served when both are set
+	TLSCert string `yaml:"tls_cert,omitempty"`
+	TLSKey  string `yaml:"tls_key,omitempty"`
+	// TrustedProxies are addresses or networks allowed to set X-Forwarded-For, none by default
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	// Mode is gin mode: release, debug or test
+	Mode string `yaml:"mode"`
+	// RefreshInterval is the minimal time between on-demand refreshes of a source through api
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// PublicUrl is the address the site is reachable at, used for absolute links in generated feeds.
+	// If empty, it's guessed from requests.
+	PublicUrl string `yaml:"public_url,omitempty"`
+}
+
+// QueueConfig holds settings of the fetch job queue used by collect --scheduler and --worker
+type QueueConfig struct {
+	// MaxAttempts is how many times a job is tried before it's moved to dead state
+	MaxAttempts int `yaml:"max_attempts"`
+	// VisibilityTimeout is how long a claimed job is hidden from other workers.
+	// Jobs of workers that died become available again after it.
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"`
+	PollInterval      time.Duration `yaml:"poll_interval"`
+	// Concurrency is the number of jobs a worker process runs at once
+	Concurrency int `yaml:"concurrency"`
+	// KeepDone is how long finished jobs are kept
+	KeepDone time.Duration `yaml:"keep_done"`
+}
+
+// WebSubConfig holds settings of push subscriptions to feeds that advertise a WebSub hub
+type WebSubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CallbackUrl is the address of the serve http server that hubs deliver content to,
+	// server.public_url by default
+	CallbackUrl string `yaml:"callback_url,omitempty"`
+	// Lease is the subscription duration asked from hubs, they may choose another one
+	Lease time.Duration `yaml:"lease"`
+	// FallbackUpdate is the polling period of sources with active subscriptions,
+	// unless their own update period is longer
+	FallbackUpdate time.Duration `yaml:"fallback_update"`
+	// AllowPrivateHubs lets hubs on loopback, link-local and private addresses be used.
+	// Hub addresses come from feed content, so they are refused by default.
+	AllowPrivateHubs bool `yaml:"allow_private_hubs"`
+}
+
+// AuthConfig holds settings of user accounts
+type AuthConfig struct {
+	// RequireLogin hides pages, api and feeds from anonymous visitors
+	RequireLogin bool `yaml:"require_login"`
+	// SessionTTL is how long a login is valid
+	SessionTTL time.Duration `yaml:"session_ttl"`
+}
+
 type SourceConfig struct {
 	Name         string              `yaml:"name"`
 	FeedUrl      string              `yaml:"url"`
 	Timeout      time.Duration       `yaml:"timeout"`
 	UpdatePeriod time.Duration       `yaml:"update"`
-	Tags         map[string][]string `yaml:"tags"`
+	Tags         map[string][]string `yaml:"tags,omitempty"`
+	Retention    RetentionPolicy     `yaml:"retention,omitempty"`
+	// File is the config file the source is defined in
+	File string `yaml:"file,omitempty"`
+	// PublicOnly restricts fetching to public addresses, it's set for sources added through api
+	PublicOnly bool `yaml:"-"`
+
+	lines keyLines
 }
 
 type Config struct {
-	Version      string         `yaml:"-"`
-	DbConnString string         `yaml:"db"`
-	ListenAddr   string         `yaml:"listen_addr"`
-	Sources      []SourceConfig `yaml:"sources,flow"`
+	Version      string          `yaml:"-"`
+	DbConnString string          `yaml:"db"`
+	ListenAddr   string          `yaml:"listen_addr"`
+	Server       ServerConfig    `yaml:"server"`
+	TrackUpdates bool            `yaml:"track_updates"`
+	Defaults     DefaultsConfig  `yaml:"defaults"`
+	Retention    RetentionConfig `yaml:"retention"`
+	Queue        QueueConfig     `yaml:"queue"`
+	WebSub       WebSubConfig    `yaml:"websub"`
+	Auth         AuthConfig      `yaml:"auth"`
+	Include      []string        `yaml:"include,omitempty"`
+	Sources      []SourceConfig  `yaml:"sources"`
+	// Origin tells configs of processes sharing a database apart: syncing sources only removes
+	// the ones defined by the same origin. It's the absolute path of the config file by default.
+	Origin string `yaml:"origin,omitempty"`
+
+	lines    keyLines
+	files    []string
+	patterns []string
+}
+
+// Equal reports whether two source configs have the same settings,
+// no matter where they are defined
+func (s SourceConfig) Equal(other SourceConfig) bool {
+	s.lines, other.lines = nil, nil
+	s.File, other.File = "", ""
+	return reflect.DeepEqual(s, other)
+}
+
+// applyDefaults fills in source settings that weren't set explicitly.
+// Tag categories from defaults are added to sources that don't have them.
+func (c *Config) applyDefaults() {
+	if c.Server.ReadHeaderTimeout == 0 {
+		c.Server.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if c.Server.ReadTimeout == 0 {
+		c.Server.ReadTimeout = defaultReadTimeout
+	}
+	if c.Server.WriteTimeout == 0 {
+		c.Server.WriteTimeout = defaultWriteTimeout
+	}
+	if c.Server.IdleTimeout == 0 {
+		c.Server.IdleTimeout = defaultIdleTimeout
+	}
+	if c.Server.ShutdownTimeout == 0 {
+		c.Server.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if c.Server.MaxHeaderBytes == 0 {
+		c.Server.MaxHeaderBytes = defaultMaxHeaderBytes
+	}
+	if c.Server.Mode == "" {
+		c.Server.Mode = defaultServerMode
+	}
+	if c.Server.RefreshInterval == 0 {
+		c.Server.RefreshInterval = defaultRefreshInterval
+	}
+
+	if c.Queue.MaxAttempts == 0 {
+		c.Queue.MaxAttempts = defaultQueueMaxAttempts
+	}
+	if c.Queue.VisibilityTimeout == 0 {
+		c.Queue.VisibilityTimeout = defaultQueueVisibilityTimeout
+	}
+	if c.Queue.PollInterval == 0 {
+		c.Queue.PollInterval = defaultQueuePollInterval
+	}
+	if c.Queue.Concurrency == 0 {
+		c.Queue.Concurrency = defaultQueueConcurrency
+	}
+	if c.Queue.KeepDone == 0 {
+		c.Queue.KeepDone = defaultQueueKeepDone
+	}
+
+	if c.WebSub.CallbackUrl == "" {
+		c.WebSub.CallbackUrl = c.Server.PublicUrl
+	}
+	if c.WebSub.Lease == 0 {
+		c.WebSub.Lease = defaultWebSubLease
+	}
+	if c.WebSub.FallbackUpdate == 0 {
+		c.WebSub.FallbackUpdate = defaultWebSubFallbackUpdate
+	}
+
+	if c.Auth.SessionTTL == 0 {
+		c.Auth.SessionTTL = defaultSessionTTL
+	}
+
+	if c.Defaults.Timeout == 0 {
+		c.Defaults.Timeout = defaultTimeout
+	}
+	if c.Defaults.UpdatePeriod == 0 {
+		c.Defaults.UpdatePeriod = defaultUpdatePeriod
+	}
+
+	for i := range c.Sources {
+		s := &c.Sources[i]
+		if s.Timeout == 0 {
+			s.Timeout = c.Defaults.Timeout
+		}
+		if s.UpdatePeriod == 0 {
+			s.UpdatePeriod = c.Defaults.UpdatePeriod
+		}
+
+		for tagCat, tagVals := range c.Defaults.Tags {
+			if _, ok := s.Tags[tagCat]; ok {
+				continue
+			}
+			if s.Tags == nil {
+				s.Tags = make(map[string][]string)
+			}
+			s.Tags[tagCat] = slices.Clone(tagVals)
+		}
+	}
+}
+
+// RetentionFor returns retention policy for the resource with given name.
+// Values set for the source take precedence over global ones.
+func (c Config) RetentionFor(name string) RetentionPolicy {
+	policy := c.Retention.RetentionPolicy
+
+	for _, s := range c.Sources {
+		if s.Name != name {
+			continue
+		}
+		if s.Retention.MaxAge != 0 {
+			policy.MaxAge = s.Retention.MaxAge
+		}
+		if s.Retention.MaxArticles != 0 {
+			policy.MaxArticles = s.Retention.MaxArticles
+		}
+		if s.Retention.DropItemAfter != 0 {
+			policy.DropItemAfter = s.Retention.DropItemAfter
+		}
+		break
+	}
+
+	return policy
 }
 
 func (c Config) GetAllTags() map[string][]string {
@@ -117,10 +371,41 @@ func (c Config) GetResourcesWithTags(tagStrings []string) ([]string, error) {
 }
 
 func parse(configBytes []byte) (Config, error) {
+	return parseWithEnv(configBytes, ".", noEnv)
+}
+
+// parseWithEnv parses config text, taking environment variables into account:
+// ${VAR} references are interpolated, and ALLNEWS_* variables override values from the text.
+// Relative paths of *_file keys are resolved from baseDir.
+func parseWithEnv(configBytes []byte, baseDir string, lookupEnv lookupEnvFunc) (Config, error) {
 	var config Config
 
-	err := yaml.Unmarshal(configBytes, &config)
-	return config, err
+	configBytes, err := interpolate(configBytes, lookupEnv)
+	if err != nil {
+		return config, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(configBytes, &root); err != nil {
+		return config, err
+	}
+
+	if err := resolveFileKeys(&root, baseDir); err != nil {
+		return config, err
+	}
+
+	if len(root.Content) > 0 {
+		if err := root.Decode(&config); err != nil {
+			return config, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&config).Elem(), envPrefix, lookupEnv); err != nil {
+		return config, err
+	}
+
+	config.applyDefaults()
+	return config, nil
 }
 
 func Get(filename string, appVersion string) (Config, error) {
@@ -129,10 +414,17 @@ func Get(filename string, appVersion string) (Config, error) {
 		return Config{}, err
 	}
 
-	config, err := parse(data)
+	config, err := parseWithEnv(data, filepath.Dir(filename), os.LookupEnv)
 	if err != nil {
+		return config, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if err := config.loadIncludes(filename, os.LookupEnv); err != nil {
 		return config, err
 	}
+	if config.Origin == "" {
+		config.Origin = config.files[0]
+	}
 
 	config.Version = appVersion
 	return config, nil
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "ALLNEWS"
+
+type lookupEnvFunc func(string) (string, bool)
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+// ${VAR} or ${VAR:-default}; $${VAR} is left as is
+var interpolationRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolate replaces ${VAR} references in config text with values of environment variables
+func interpolate(data []byte, lookupEnv lookupEnvFunc) ([]byte, error) {
+	missing := make([]string, 0)
+
+	result := interpolationRe.ReplaceAllFunc(data, func(match []byte) []byte {
+		if match[1] == '$' {
+			return match[1:]
+		}
+
+		groups := interpolationRe.FindSubmatch(match)
+		name := string(groups[1])
+		if value, ok := lookupEnv(name); ok {
+			return []byte(value)
+		}
+		if groups[2] != nil {
+			return groups[2]
+		}
+
+		missing = append(missing, name)
+		return match
+	})
+
+	if len(missing) > 0 {
+		return result, fmt.Errorf("environment variables used in config are not set: %s", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+func readSecretFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveFileKeys replaces `key_file: path` pairs in yaml mappings with `key: <file contents>`.
+// Relative paths are resolved from baseDir.
+func resolveFileKeys(node *yaml.Node, baseDir string) error {
+	if node.Kind == yaml.DocumentNode || node.Kind == yaml.SequenceNode {
+		for _, n := range node.Content {
+			if err := resolveFileKeys(n, baseDir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	keys := make(map[string]struct{})
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys[node.Content[i].Value] = struct{}{}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if !strings.HasSuffix(key.Value, "_file") || value.Kind != yaml.ScalarNode {
+			if err := resolveFileKeys(value, baseDir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := strings.TrimSuffix(key.Value, "_file")
+		if _, ok := keys[name]; ok {
+			return fmt.Errorf("line %d: both %s and %s are set", key.Line, name, key.Value)
+		}
+
+		filename := value.Value
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(baseDir, filename)
+		}
+		secret, err := readSecretFile(filename)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", key.Line, err)
+		}
+
+		key.Value = name
+		value.Value = secret
+		value.Tag = "!!str"
+		value.Style = yaml.DoubleQuotedStyle
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides config values with ALLNEWS_* environment variables named after yaml keys,
+// e.g. ALLNEWS_DB or ALLNEWS_RETENTION_MAX_AGE. Every variable also has a _FILE variant
+// that reads the value from a file. Lists of structs, like sources, can't be overridden.
+func applyEnv(v reflect.Value, prefix string, lookupEnv lookupEnvFunc) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+
+		fieldValue := v.Field(i)
+		inline := len(tag) > 1 && tag[1] == "inline"
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			fieldPrefix := prefix
+			if !inline {
+				fieldPrefix = prefix + "_" + strings.ToUpper(name)
+			}
+			if err := applyEnv(fieldValue, fieldPrefix, lookupEnv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+
+		envName := prefix + "_" + strings.ToUpper(name)
+		value, ok := lookupEnv(envName)
+		if !ok {
+			filename, fileOk := lookupEnv(envName + "_FILE")
+			if !fileOk {
+				continue
+			}
+
+			var err error
+			value, err = readSecretFile(filename)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", envName, err)
+			}
+		}
+
+		if field.Type.Kind() == reflect.String {
+			fieldValue.SetString(value)
+			continue
+		}
+
+		if err := yaml.Unmarshal([]byte(value), fieldValue.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %w", envName, err)
+		}
+	}
+
+	return nil
+}
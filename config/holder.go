@@ -0,0 +1,33 @@
+package config
+
+import "sync"
+
+// Holder keeps current config of a long-running process that can be replaced on reload
+type Holder struct {
+	mu     sync.RWMutex
+	config Config
+}
+
+func NewHolder(config Config) *Holder {
+	return &Holder{config: config}
+}
+
+func (h *Holder) Get() Config {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config
+}
+
+func (h *Holder) Set(config Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config = config
+}
+
+func (h *Holder) GetAllTags() map[string][]string {
+	return h.Get().GetAllTags()
+}
+
+func (h *Holder) GetResourcesWithTags(tagStrings []string) ([]string, error) {
+	return h.Get().GetResourcesWithTags(tagStrings)
+}
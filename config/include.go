@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// sourcesDir is a directory next to the config file with additional source files,
+// loaded without being listed in include
+const sourcesDir = "sources.d"
+
+// sourcesFile is a file with additional sources.
+// Its tags are added to every source in the file that doesn't set them.
+type sourcesFile struct {
+	Tags    map[string][]string `yaml:"tags"`
+	Sources []SourceConfig      `yaml:"sources"`
+}
+
+func readSourcesFile(filename string, lookupEnv lookupEnvFunc) (sourcesFile, error) {
+	var f sourcesFile
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return f, err
+	}
+
+	data, err = interpolate(data, lookupEnv)
+	if err != nil {
+		return f, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return f, err
+	}
+	if err := resolveFileKeys(&root, filepath.Dir(filename)); err != nil {
+		return f, err
+	}
+	if len(root.Content) > 0 {
+		if err := root.Decode(&f); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// loadIncludes adds sources from files matching include patterns and sources.d/*.yml
+// to the sources of the main config file. Every source remembers the file it came from,
+// relative to the directory of the main config file.
+func (c *Config) loadIncludes(filename string, lookupEnv lookupEnvFunc) error {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	baseDir := filepath.Dir(filename)
+
+	relative := func(path string) string {
+		if rel, err := filepath.Rel(baseDir, path); err == nil {
+			return rel
+		}
+		return path
+	}
+
+	for i := range c.Sources {
+		c.Sources[i].File = relative(filename)
+	}
+
+	c.files = []string{filename}
+	c.patterns = make([]string, 0, len(c.Include)+1)
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+		c.patterns = append(c.patterns, pattern)
+	}
+	c.patterns = append(c.patterns, filepath.Join(baseDir, sourcesDir, "*.yml"))
+
+	for _, pattern := range c.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("include %q: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			if slices.Contains(c.files, match) {
+				continue
+			}
+
+			f, err := readSourcesFile(match, lookupEnv)
+			if err != nil {
+				return fmt.Errorf("%s: %w", relative(match), err)
+			}
+
+			for _, s := range f.Sources {
+				s.File = relative(match)
+				for tagCat, tagVals := range f.Tags {
+					if _, ok := s.Tags[tagCat]; ok {
+						continue
+					}
+					if s.Tags == nil {
+						s.Tags = make(map[string][]string)
+					}
+					s.Tags[tagCat] = slices.Clone(tagVals)
+				}
+				c.Sources = append(c.Sources, s)
+			}
+			c.files = append(c.files, match)
+		}
+	}
+
+	c.applyDefaults()
+	return nil
+}
+
+// Files returns absolute paths of the config file and all the files included from it
+func (c Config) Files() []string {
+	return c.files
+}
+
+// IncludePatterns returns absolute glob patterns of files that are included into config,
+// including ones that don't match anything yet
+func (c Config) IncludePatterns() []string {
+	return c.patterns
+}
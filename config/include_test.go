@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, filename string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "config.yml"), `
+db: postgres://localhost/db
+include:
+  - teams/*.yml
+sources:
+  - name: main
+    url: https://main.com/rss
+`)
+	writeFile(t, filepath.Join(dir, "teams", "sports.yml"), `
+tags:
+  topic: [sports]
+sources:
+  - name: espn
+    url: https://espn.com/rss
+  - name: bbc
+    url: https://bbc.com/rss
+    tags:
+      topic: [news]
+`)
+	writeFile(t, filepath.Join(dir, sourcesDir, "tech.yml"), `
+sources:
+  - name: hn
+    url: https://hn.com/rss
+`)
+	writeFile(t, filepath.Join(dir, sourcesDir, "ignored.txt"), "not yaml: [")
+
+	config, err := Get(filepath.Join(dir, "config.yml"), "test")
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+
+	files := make(map[string]string)
+	for _, s := range config.Sources {
+		files[s.Name] = s.File
+		assert.Equal(t, defaultUpdatePeriod, s.UpdatePeriod)
+	}
+	assert.Equal(t, map[string]string{
+		"main": "config.yml",
+		"espn": filepath.Join("teams", "sports.yml"),
+		"bbc":  filepath.Join("teams", "sports.yml"),
+		"hn":   filepath.Join(sourcesDir, "tech.yml"),
+	}, files)
+
+	resources, err := config.GetResourcesWithTags([]string{"topic:sports"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"espn"}, resources)
+
+	assert.Len(t, config.Files(), 3)
+	assert.Len(t, config.IncludePatterns(), 2)
+	assert.Equal(t, config.Files()[0], config.Origin)
+
+	t.Run("duplicates across files", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, sourcesDir, "dup.yml"), `
+sources:
+  - name: espn
+    url: https://espn.com/other
+`)
+		defer os.Remove(filepath.Join(dir, sourcesDir, "dup.yml"))
+
+		config, err := Get(filepath.Join(dir, "config.yml"), "test")
+		assert.Nil(t, err)
+
+		var errs ValidationErrors
+		assert.True(t, errors.As(config.Validate(), &errs))
+		assert.Equal(t, []ValidationError{{
+			File:    filepath.Join(sourcesDir, "dup.yml"),
+			Line:    3,
+			Message: `source "espn": duplicate name, already defined at ` + filepath.Join("teams", "sports.yml") + ":5",
+		}}, []ValidationError(errs))
+	})
+
+	t.Run("broken include", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "teams", "broken.yml"), "sources: {")
+		defer os.Remove(filepath.Join(dir, "teams", "broken.yml"))
+
+		_, err := Get(filepath.Join(dir, "config.yml"), "test")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), filepath.Join("teams", "broken.yml"))
+	})
+}
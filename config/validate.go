@@ -0,0 +1,340 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// maxSourceNameLength is the length of source name columns in the database
+const maxSourceNameLength = 50
+
+// keyLines maps yaml keys of a mapping to the lines they are defined at
+type keyLines map[string]int
+
+func newKeyLines(node *yaml.Node) keyLines {
+	lines := keyLines{"": node.Line}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		lines[node.Content[i].Value] = node.Content[i].Line
+	}
+	return lines
+}
+
+// get returns line of the key, or line of the mapping itself if key isn't there
+func (l keyLines) get(key string) int {
+	if line, ok := l[key]; ok {
+		return line
+	}
+	return l[""]
+}
+
+func (s *SourceConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain SourceConfig
+	if err := value.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	s.lines = newKeyLines(value)
+	return nil
+}
+
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	type plain Config
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	c.lines = newKeyLines(value)
+	// a missing top-level key isn't located anywhere in particular
+	delete(c.lines, "")
+	return nil
+}
+
+type ValidationError struct {
+	// File is set for problems in sources, which can come from included files
+	File    string
+	Line    int
+	Message string
+}
+
+func location(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+func (e ValidationError) String() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", location(e.File, e.Line), e.Message)
+}
+
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, ve := range e {
+		messages = append(messages, ve.String())
+	}
+	return "invalid config:\n" + strings.Join(messages, "\n")
+}
+
+func validateFeedUrl(feedUrl string) error {
+	if feedUrl == "" {
+		return fmt.Errorf("url is required")
+	}
+
+	u, err := url.Parse(feedUrl)
+	if err != nil {
+		return fmt.Errorf("malformed url %q: %v", feedUrl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q has to start with http:// or https://", feedUrl)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", feedUrl)
+	}
+	return nil
+}
+
+// ValidatePublicFeedUrl also refuses urls pointing to loopback, link-local, private
+// or unspecified addresses, so that sources added through the api can't reach internal services.
+// Host names other than localhost aren't resolved here, addresses they resolve to
+// are checked when the feed is fetched.
+func ValidatePublicFeedUrl(feedUrl string) error {
+	if err := validateFeedUrl(feedUrl); err != nil {
+		return err
+	}
+	u, _ := url.Parse(feedUrl)
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("url %q points to a local address", feedUrl)
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return fmt.Errorf("url %q points to a non-public address", feedUrl)
+	}
+	return nil
+}
+
+// IsPublicIP reports whether ip isn't a loopback, link-local, private, unspecified or multicast address
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+func validateTags(tags map[string][]string) []string {
+	problems := make([]string, 0)
+	tagCats := maps.Keys(tags)
+	slices.Sort(tagCats)
+	for _, tagCat := range tagCats {
+		tagVals := tags[tagCat]
+		if tagCat == "" || strings.Contains(tagCat, ":") {
+			problems = append(problems, fmt.Sprintf("tag category %q can't be empty or contain ':'", tagCat))
+		}
+		for _, tagVal := range tagVals {
+			if tagVal == "" || strings.Contains(tagVal, ":") {
+				problems = append(problems, fmt.Sprintf("value %q of tag %q can't be empty or contain ':'", tagVal, tagCat))
+			}
+		}
+	}
+	return problems
+}
+
+func validateRetention(p RetentionPolicy) []string {
+	problems := make([]string, 0)
+	if p.MaxAge < 0 {
+		problems = append(problems, "retention max_age can't be negative")
+	}
+	if p.MaxArticles < 0 {
+		problems = append(problems, "retention max_articles can't be negative")
+	}
+	if p.DropItemAfter < 0 {
+		problems = append(problems, "retention drop_item_after can't be negative")
+	}
+	return problems
+}
+
+func validateServer(listenAddr string, s ServerConfig) []string {
+	problems := make([]string, 0)
+
+	if strings.HasPrefix(listenAddr, "unix:") && strings.TrimPrefix(listenAddr, "unix:") == "" {
+		problems = append(problems, "listen_addr unix: needs a socket path")
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_timeout", s.ReadTimeout},
+		{"read_header_timeout", s.ReadHeaderTimeout},
+		{"write_timeout", s.WriteTimeout},
+		{"idle_timeout", s.IdleTimeout},
+		{"shutdown_timeout", s.ShutdownTimeout},
+		{"refresh_interval", s.RefreshInterval},
+	}
+	for _, t := range timeouts {
+		if t.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s can't be negative", t.name))
+		}
+	}
+	if s.MaxHeaderBytes < 0 {
+		problems = append(problems, "max_header_bytes can't be negative")
+	}
+
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		problems = append(problems, "tls_cert and tls_key have to be set together")
+	}
+
+	for _, proxy := range s.TrustedProxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			problems = append(problems, fmt.Sprintf("trusted proxy %q is neither an ip address nor a network", proxy))
+		}
+	}
+
+	if !slices.Contains([]string{"release", "debug", "test"}, s.Mode) {
+		problems = append(problems, fmt.Sprintf("mode has to be release, debug or test, got %q", s.Mode))
+	}
+
+	if s.PublicUrl != "" {
+		if err := validateFeedUrl(s.PublicUrl); err != nil {
+			problems = append(problems, "public_"+err.Error())
+		}
+	}
+	return problems
+}
+
+func validateWebSub(w WebSubConfig) []string {
+	problems := make([]string, 0)
+	if w.Lease < 0 || w.FallbackUpdate < 0 {
+		problems = append(problems, "lease and fallback_update can't be negative")
+	}
+	if !w.Enabled {
+		return problems
+	}
+
+	if w.CallbackUrl == "" {
+		problems = append(problems, "callback_url or server.public_url is required to receive pushed content")
+	} else if err := validateFeedUrl(w.CallbackUrl); err != nil {
+		problems = append(problems, "callback_"+err.Error())
+	}
+	return problems
+}
+
+// Validate returns ValidationErrors with all problems found in the config, or nil if there are none
+func (c Config) Validate() error {
+	errs := make(ValidationErrors, 0)
+	add := func(line int, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.DbConnString == "" {
+		add(c.lines.get("db"), "db connection string is required")
+	}
+
+	for _, problem := range validateTags(c.Defaults.Tags) {
+		add(c.lines.get("defaults"), "defaults: %s", problem)
+	}
+	if c.Defaults.Timeout < 0 || c.Defaults.UpdatePeriod < 0 {
+		add(c.lines.get("defaults"), "defaults: timeout and update can't be negative")
+	}
+
+	for _, problem := range validateRetention(c.Retention.RetentionPolicy) {
+		add(c.lines.get("retention"), "%s", problem)
+	}
+	if c.Retention.Interval < 0 {
+		add(c.lines.get("retention"), "retention interval can't be negative")
+	}
+
+	for _, problem := range validateServer(c.ListenAddr, c.Server) {
+		add(c.lines.get("server"), "server: %s", problem)
+	}
+
+	if c.Queue.MaxAttempts < 0 || c.Queue.Concurrency < 0 {
+		add(c.lines.get("queue"), "queue: max_attempts and concurrency can't be negative")
+	}
+	if c.Queue.VisibilityTimeout < 0 || c.Queue.PollInterval < 0 || c.Queue.KeepDone < 0 {
+		add(c.lines.get("queue"), "queue: visibility_timeout, poll_interval and keep_done can't be negative")
+	}
+
+	for _, problem := range validateWebSub(c.WebSub) {
+		add(c.lines.get("websub"), "websub: %s", problem)
+	}
+
+	if c.Auth.SessionTTL < 0 {
+		add(c.lines.get("auth"), "auth: session_ttl can't be negative")
+	}
+
+	// sources can also be added through api, so config without them is fine
+	seen := make(map[string]string)
+	for i, s := range c.Sources {
+		label := strconv.Quote(s.Name)
+		if s.Name == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		} else if defined, ok := seen[s.Name]; ok {
+			errs = append(errs, ValidationError{
+				File:    s.File,
+				Line:    s.lines.get("name"),
+				Message: fmt.Sprintf("source %s: duplicate name, already defined at %s", label, defined),
+			})
+		} else {
+			seen[s.Name] = location(s.File, s.lines.get("name"))
+		}
+
+		errs = append(errs, s.validate(label)...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (s SourceConfig) validate(label string) ValidationErrors {
+	errs := make(ValidationErrors, 0)
+	add := func(line int, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{File: s.File, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Name == "" {
+		add(s.lines.get("name"), "source %s: name is required", label)
+	} else if n := utf8.RuneCountInString(s.Name); n > maxSourceNameLength {
+		add(s.lines.get("name"), "source %s: name is too long (%d characters, max %d)", label, n, maxSourceNameLength)
+	}
+	if err := validateFeedUrl(s.FeedUrl); err != nil {
+		add(s.lines.get("url"), "source %s: %v", label, err)
+	}
+	if s.Timeout <= 0 {
+		add(s.lines.get("timeout"), "source %s: timeout has to be positive", label)
+	}
+	if s.UpdatePeriod < time.Second {
+		add(s.lines.get("update"), "source %s: update period has to be at least 1s, got %s", label, s.UpdatePeriod)
+	}
+	for _, problem := range validateTags(s.Tags) {
+		add(s.lines.get("tags"), "source %s: %s", label, problem)
+	}
+	for _, problem := range validateRetention(s.Retention) {
+		add(s.lines.get("retention"), "source %s: %s", label, problem)
+	}
+	return errs
+}
+
+// Validate checks a single source, e.g. one that is added through api.
+// It returns ValidationErrors or nil.
+func (s SourceConfig) Validate() error {
+	errs := s.validate(strconv.Quote(s.Name))
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
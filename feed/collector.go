@@ -0,0 +1,259 @@
+package feed
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+)
+
+// CollectorSourceStatus describes schedule of a source in a running collector
+type CollectorSourceStatus struct {
+	Name     string     `json:"name"`
+	LastRun  *time.Time `json:"last_run"`
+	NextRun  time.Time  `json:"next_run"`
+	Fetching bool       `json:"fetching"`
+	// Owned is false if another collector held the source lease at the last scheduled run
+	Owned bool `json:"owned"`
+}
+
+type scheduledSource struct {
+	config config.SourceConfig
+	stop   chan struct{}
+	mu     sync.Mutex
+	status CollectorSourceStatus
+}
+
+func (s *scheduledSource) started(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastRun = &now
+	s.status.Fetching = true
+}
+
+func (s *scheduledSource) setOwned(owned bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Owned = owned
+}
+
+func (s *scheduledSource) finished(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.NextRun = next
+	s.status.Fetching = false
+}
+
+func (s *scheduledSource) getStatus() CollectorSourceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *scheduledSource) getLastRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.LastRun == nil {
+		return time.Time{}
+	}
+	return *s.status.LastRun
+}
+
+// Leaser lets collectors sharing a database agree on which of them fetches a source
+type Leaser interface {
+	ClaimSource(ctx context.Context, name string) (bool, error)
+	ReleaseSource(ctx context.Context, name string) error
+}
+
+// Collector periodically fetches a set of sources. The set can be changed while it's running
+// without interrupting fetches of sources that stay the same.
+type Collector struct {
+	storage ArticleSaver
+	fetch   func(context.Context, config.SourceConfig, ArticleSaver) FetchResult
+	leaser  Leaser
+	websub  *WebSub
+
+	mu      sync.Mutex
+	sources map[string]*scheduledSource
+	wg      sync.WaitGroup
+}
+
+type CollectorOption func(*Collector)
+
+// WithLeaser makes collector fetch only sources it holds a lease for,
+// so that several collectors can run against the same database
+func WithLeaser(leaser Leaser) CollectorOption {
+	return func(c *Collector) {
+		c.leaser = leaser
+	}
+}
+
+// WithWebSub makes collector subscribe sources to hubs their feeds advertise
+// and poll subscribed ones less often
+func WithWebSub(websub *WebSub) CollectorOption {
+	return func(c *Collector) {
+		c.websub = websub
+	}
+}
+
+func NewCollector(storage ArticleSaver, options ...CollectorOption) *Collector {
+	c := &Collector{
+		storage: storage,
+		fetch:   processFeed,
+		sources: make(map[string]*scheduledSource),
+	}
+	for _, f := range options {
+		f(c)
+	}
+	return c
+}
+
+// claim reports whether the source should be fetched by this collector
+func (c *Collector) claim(ctx context.Context, s *scheduledSource) bool {
+	if c.leaser == nil {
+		s.setOwned(true)
+		return true
+	}
+
+	owned, err := c.leaser.ClaimSource(ctx, s.config.Name)
+	if err != nil {
+		log.Printf("Error: claiming %s: %v", s.config.Name, err)
+		return false
+	}
+	s.setOwned(owned)
+	return owned
+}
+
+// Apply makes the collector fetch given sources: new ones are started, removed ones are stopped
+// and changed ones are rescheduled keeping the time of their last fetch.
+// Fetches that are already running are allowed to finish.
+func (c *Collector) Apply(ctx context.Context, sources []config.SourceConfig) {
+	// hubs are asked to unsubscribe removed sources after the lock is released
+	removed := make([]string, 0)
+	defer func() {
+		for _, name := range removed {
+			c.websub.Unsubscribe(ctx, name)
+		}
+	}()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wanted := make(map[string]config.SourceConfig, len(sources))
+	for _, s := range sources {
+		wanted[s.Name] = s
+	}
+
+	for name, running := range c.sources {
+		newConfig, ok := wanted[name]
+		if ok && running.config.Equal(newConfig) {
+			continue
+		}
+
+		close(running.stop)
+		delete(c.sources, name)
+
+		if !ok {
+			log.Printf("%s removed from config, stopping", name)
+			if c.leaser != nil {
+				if err := c.leaser.ReleaseSource(ctx, name); err != nil {
+					log.Printf("Error: releasing %s: %v", name, err)
+				}
+			}
+			if c.websub != nil {
+				removed = append(removed, name)
+			}
+			continue
+		}
+
+		log.Printf("%s changed, rescheduling", name)
+		delay := time.Until(running.getLastRun().Add(newConfig.UpdatePeriod))
+		c.start(ctx, newConfig, delay)
+	}
+
+	for name, s := range wanted {
+		if _, ok := c.sources[name]; ok {
+			continue
+		}
+		c.start(ctx, s, 0)
+	}
+}
+
+// start has to be called with c.mu held
+func (c *Collector) start(ctx context.Context, source config.SourceConfig, delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+
+	s := &scheduledSource{config: source, stop: make(chan struct{})}
+	s.status.Name = source.Name
+	s.status.NextRun = time.Now().Add(delay)
+	c.sources[source.Name] = s
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("%s collect loop terminating", source.Name)
+				return
+			case <-s.stop:
+				return
+			case <-timer.C:
+				period := source.UpdatePeriod
+				if c.claim(ctx, s) {
+					s.started(time.Now())
+					result := c.fetch(ctx, source, c.storage)
+					if c.websub != nil {
+						c.websub.Check(ctx, source, result)
+						period = c.websub.PollPeriod(ctx, source)
+					}
+				}
+				s.finished(time.Now().Add(period))
+				timer.Reset(period)
+			}
+		}
+	}()
+}
+
+// Sources returns names of sources that are currently scheduled
+func (c *Collector) Sources() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, 0, len(c.sources))
+	for name := range c.sources {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Status returns schedule of every source, ordered by name
+func (c *Collector) Status() []CollectorSourceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]CollectorSourceStatus, 0, len(c.sources))
+	for _, s := range c.sources {
+		result = append(result, s.getStatus())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Wait blocks until ctx passed to Apply is done and all source loops are finished.
+// It doesn't return early if reload leaves the collector without sources.
+func (c *Collector) Wait(ctx context.Context) {
+	<-ctx.Done()
+	c.wg.Wait()
+}
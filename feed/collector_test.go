@@ -0,0 +1,194 @@
+package feed
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fetchCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (f *fetchCounter) fetch(ctx context.Context, source config.SourceConfig, storage ArticleSaver) FetchResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[source.Name]++
+	return FetchResult{Source: source.Name}
+}
+
+func (f *fetchCounter) get(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.counts[name]
+}
+
+func TestCollectorApply(t *testing.T) {
+	counter := &fetchCounter{counts: make(map[string]int)}
+	collector := NewCollector(newTestStorage())
+	collector.fetch = counter.fetch
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	first := config.SourceConfig{Name: "first", FeedUrl: "http://first", UpdatePeriod: time.Millisecond * 50}
+	second := config.SourceConfig{Name: "second", FeedUrl: "http://second", UpdatePeriod: time.Hour}
+
+	collector.Apply(ctx, []config.SourceConfig{first, second})
+	assert.Eventually(t, func() bool { return counter.get("first") >= 2 }, time.Second*5, time.Millisecond*5)
+	assert.Equal(t, 1, counter.get("second"))
+
+	// unchanged source keeps its schedule, changed one waits for its new period since last fetch
+	third := config.SourceConfig{Name: "third", FeedUrl: "http://third", UpdatePeriod: time.Hour}
+	second.UpdatePeriod = time.Hour * 2
+	collector.Apply(ctx, []config.SourceConfig{second, third})
+	assert.Eventually(t, func() bool { return counter.get("third") == 1 }, time.Second*5, time.Millisecond*5)
+
+	names := collector.Sources()
+	sort.Strings(names)
+	assert.Equal(t, []string{"second", "third"}, names)
+	// a fetch of the removed source could have been already running during Apply
+	firstCount := counter.get("first")
+	assert.Never(t, func() bool {
+		return counter.get("first") != firstCount || counter.get("second") != 1
+	}, first.UpdatePeriod*3, time.Millisecond*5)
+
+	cancel()
+	collector.Wait(ctx)
+}
+
+func TestCollectorWait(t *testing.T) {
+	collector := NewCollector(newTestStorage())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		collector.Wait(ctx)
+		close(done)
+	}()
+	isDone := func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// reload that removes every source doesn't stop the collector
+	collector.Apply(ctx, []config.SourceConfig{{Name: "test", UpdatePeriod: time.Hour}})
+	collector.Apply(ctx, []config.SourceConfig{})
+	assert.Never(t, isDone, time.Millisecond*50, time.Millisecond*5)
+
+	cancel()
+	assert.Eventually(t, isDone, time.Second*5, time.Millisecond*5)
+}
+
+func TestCollectorStatus(t *testing.T) {
+	collector := NewCollector(newTestStorage())
+	release := make(chan struct{})
+	collector.fetch = func(ctx context.Context, source config.SourceConfig, storage ArticleSaver) FetchResult {
+		<-release
+		return FetchResult{Source: source.Name}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	collector.Apply(ctx, []config.SourceConfig{
+		{Name: "b", UpdatePeriod: time.Hour},
+		{Name: "a", UpdatePeriod: time.Hour},
+	})
+	assert.Eventually(t, func() bool {
+		status := collector.Status()
+		return status[0].Fetching && status[1].Fetching
+	}, time.Second*5, time.Millisecond*5)
+
+	status := collector.Status()
+	assert.Len(t, status, 2)
+	assert.Equal(t, "a", status[0].Name)
+	assert.NotNil(t, status[0].LastRun)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		status := collector.Status()
+		return !status[0].Fetching && !status[1].Fetching
+	}, time.Second*5, time.Millisecond*5)
+
+	status = collector.Status()
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status[1].NextRun, time.Second)
+
+	cancel()
+	collector.Wait(ctx)
+}
+
+// testLeaser hands out each source to the first collector that claims it
+type testLeaser struct {
+	mu     *sync.Mutex
+	owners map[string]string
+	owner  string
+}
+
+func (l *testLeaser) as(owner string) *testLeaser {
+	return &testLeaser{mu: l.mu, owners: l.owners, owner: owner}
+}
+
+func (l *testLeaser) ClaimSource(ctx context.Context, name string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if owner, ok := l.owners[name]; ok && owner != l.owner {
+		return false, nil
+	}
+	l.owners[name] = l.owner
+	return true, nil
+}
+
+func (l *testLeaser) ReleaseSource(ctx context.Context, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owners[name] == l.owner {
+		delete(l.owners, name)
+	}
+	return nil
+}
+
+func TestCollectorLeases(t *testing.T) {
+	leases := &testLeaser{mu: &sync.Mutex{}, owners: make(map[string]string)}
+	counter := &fetchCounter{counts: make(map[string]int)}
+
+	first := NewCollector(newTestStorage(), WithLeaser(leases.as("first")))
+	first.fetch = counter.fetch
+	second := NewCollector(newTestStorage(), WithLeaser(leases.as("second")))
+	second.fetch = counter.fetch
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sources := []config.SourceConfig{{Name: "test", UpdatePeriod: time.Millisecond * 20}}
+
+	first.Apply(ctx, sources)
+	assert.Eventually(t, func() bool { return first.Status()[0].Owned }, time.Second*5, time.Millisecond*5)
+	// the second collector is rescheduled after its first claim
+	applied := time.Now()
+	second.Apply(ctx, sources)
+	assert.Eventually(t, func() bool {
+		return second.Status()[0].NextRun.After(applied.Add(sources[0].UpdatePeriod / 2))
+	}, time.Second*5, time.Millisecond*5)
+
+	assert.True(t, first.Status()[0].Owned)
+	assert.False(t, second.Status()[0].Owned)
+	owned, _ := leases.as("second").ClaimSource(ctx, "test")
+	assert.False(t, owned)
+
+	// removing source from the owner lets the other collector take it over
+	fetched := counter.get("test")
+	first.Apply(ctx, []config.SourceConfig{})
+	assert.Eventually(t, func() bool {
+		return second.Status()[0].Owned && counter.get("test") > fetched
+	}, time.Second*5, time.Millisecond*5)
+
+	cancel()
+	first.Wait(ctx)
+	second.Wait(ctx)
+}
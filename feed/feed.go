@@ -1,11 +1,21 @@
 package feed
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/comfyprog/allnews/config"
@@ -17,29 +27,157 @@ type ArticleStats struct {
 	TotalArticles int
 	FirstDate     time.Time
 	LastDate      time.Time
+	// Owner is the collector instance currently fetching the resource, if any
+	Owner string
+}
+
+// Source is a feed source stored in the database. Sources from config file are copied there
+// and can only be enabled or disabled, others are managed through api.
+type Source struct {
+	config.SourceConfig
+	Enabled    bool
+	FromConfig bool
+	UpdatedAt  time.Time
 }
 
 type Article struct {
-	Resource    string    `json:"resource"`
-	Url         string    `json:"url"`
-	Title       string    `json:"title"`
-	Published   time.Time `json:"published"`
-	Description string    `json:"description"`
-	ItemJSON    string    `json:"-"`
+	// ID is assigned by storage, zero for articles that aren't saved yet
+	ID          int64      `json:"id"`
+	Resource    string     `json:"resource"`
+	Url         string     `json:"url"`
+	Title       string     `json:"title"`
+	Published   time.Time  `json:"published"`
+	Description string     `json:"description"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	ItemJSON    string     `json:"-"`
 }
 
 func (a Article) String() string {
 	return fmt.Sprintf("%s [%s]: %s %s", a.Resource, a.Url, a.Published, a.Title)
 }
 
+// ContentHash is used to detect changes of already collected articles
+func (a Article) ContentHash() string {
+	sum := sha256.Sum256([]byte(a.Title + "\n" + a.Description))
+	return hex.EncodeToString(sum[:])
+}
+
+// ArticleRevision is a previous version of an article
+type ArticleRevision struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	RevisedAt   time.Time `json:"revised_at"`
+}
+
+// ErrNonPublicAddress is returned when a source added through api points to an internal address
+var ErrNonPublicAddress = errors.New("connecting to non-public addresses is not allowed")
+
+// refuseNonPublic is used as net.Dialer.Control, so the address is checked after it's resolved
+func refuseNonPublic(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !config.IsPublicIP(ip) {
+		return fmt.Errorf("%s: %w", host, ErrNonPublicAddress)
+	}
+	return nil
+}
+
+// publicClient only connects to public addresses, redirects included. Proxies from
+// environment aren't used, since the address would be checked for the proxy instead.
+var publicClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   refuseNonPublic,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return config.ValidatePublicFeedUrl(req.URL.String())
+	},
+}
+
+// clientFor returns the http client to fetch the source with
+func clientFor(source config.SourceConfig) *http.Client {
+	if source.PublicOnly {
+		return publicClient
+	}
+	return http.DefaultClient
+}
+
 func GetFeed(ctx context.Context, url string, timeout time.Duration) (*gofeed.Feed, error) {
-	parser := gofeed.NewParser()
+	feed, _, err := getFeed(ctx, http.DefaultClient, url, timeout)
+	return feed, err
+}
+
+// getFeed also returns WebSub links advertised by the feed
+func getFeed(ctx context.Context, client *http.Client, url string, timeout time.Duration) (*gofeed.Feed, HubLinks, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	return parser.ParseURLWithContext(url, ctx)
+	parser := gofeed.NewParser()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, HubLinks{}, err
+	}
+	req.Header.Set("User-Agent", parser.UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, HubLinks{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, HubLinks{}, gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, HubLinks{}, err
+	}
+
+	feed, err := parser.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, HubLinks{}, err
+	}
+	return feed, discoverHub(resp.Header, body), nil
+}
+
+// articleUrl resolves relative item link against the site or the feed itself.
+// Links with schemes other than http and https, like javascript:, are reported as not ok.
+func articleUrl(feed *gofeed.Feed, link string) (string, bool) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return link, true
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link, false
+	}
+	if u.IsAbs() {
+		return link, u.Scheme == "http" || u.Scheme == "https"
+	}
+	for _, base := range []string{feed.Link, feed.FeedLink} {
+		if b, err := url.Parse(base); err == nil && (b.Scheme == "http" || b.Scheme == "https") {
+			return b.ResolveReference(u).String(), true
+		}
+	}
+	return link, true
 }
 
+// ExtractArticles converts feed items to articles, skipping items without
+// publication date or with links other than http and https
 func ExtractArticles(feed *gofeed.Feed, resource string) ([]Article, error) {
 	articles := make([]Article, 0, len(feed.Items))
 
@@ -47,13 +185,17 @@ func ExtractArticles(feed *gofeed.Feed, resource string) ([]Article, error) {
 		if item.PublishedParsed == nil {
 			continue
 		}
+		link, ok := articleUrl(feed, item.Link)
+		if !ok {
+			continue
+		}
 		itemData, err := json.Marshal(item)
 		if err != nil {
 			return articles, err
 		}
 		articles = append(articles, Article{
 			Resource:    resource,
-			Url:         item.Link,
+			Url:         link,
 			Title:       item.Title,
 			Description: item.Description,
 			Published:   *item.PublishedParsed,
@@ -64,31 +206,143 @@ func ExtractArticles(feed *gofeed.Feed, resource string) ([]Article, error) {
 	return articles, nil
 }
 
+// RejectedArticle is an article that couldn't be saved
+type RejectedArticle struct {
+	Article Article
+	Reason  string
+}
+
+type SaveResult struct {
+	Inserted   int
+	Updated    int
+	Duplicates int
+	Rejected   []RejectedArticle
+}
+
+func (r SaveResult) String() string {
+	return fmt.Sprintf("%d new, %d updated, %d duplicates, %d rejected", r.Inserted, r.Updated, r.Duplicates, len(r.Rejected))
+}
+
+// Add merges counters of other result into r
+func (r *SaveResult) Add(other SaveResult) {
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Duplicates += other.Duplicates
+	r.Rejected = append(r.Rejected, other.Rejected...)
+}
+
 type ArticleSaver interface {
-	SaveArticles(context.Context, []Article) error
+	SaveArticles(context.Context, []Article) (SaveResult, error)
 }
 
-func processFeed(ctx context.Context, feedConfig config.SourceConfig, storage ArticleSaver) {
-	log.Printf("Getting %s", feedConfig.FeedUrl)
-	feed, err := GetFeed(ctx, feedConfig.FeedUrl, feedConfig.Timeout)
+// FeedInfo is feed-level metadata
+type FeedInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+	Image       string `json:"image"`
+}
+
+// FetchResult is the outcome of fetching a source once
+type FetchResult struct {
+	Source    string
+	FetchedAt time.Time
+	ItemsSeen int
+	Saved     SaveResult
+	// Feed is nil if the feed couldn't be fetched
+	Feed *FeedInfo
+	// Hub is empty if the feed doesn't support push
+	Hub HubLinks
+	Err error
+}
+
+// SourceStatus is the outcome of the last fetch of a source and metadata of its feed
+type SourceStatus struct {
+	Source        string
+	LastFetchAt   time.Time
+	Status        string
+	Error         string
+	ItemsSeen     int
+	Inserted      int
+	LastSuccessAt *time.Time
+	Feed          FeedInfo
+}
+
+// FetchRecorder is implemented by storages that keep SourceStatus.
+// FetchSource records every result in them.
+type FetchRecorder interface {
+	RecordFetch(ctx context.Context, result FetchResult) error
+}
+
+// FetchSource gets the feed of a source and saves its articles
+func FetchSource(ctx context.Context, feedConfig config.SourceConfig, storage ArticleSaver) FetchResult {
+	result := fetchSource(ctx, feedConfig, storage)
+
+	if recorder, ok := storage.(FetchRecorder); ok {
+		if err := recorder.RecordFetch(ctx, result); err != nil {
+			log.Printf("Error: recording fetch of %s: %v", feedConfig.Name, err)
+		}
+	}
+	return result
+}
+
+func fetchSource(ctx context.Context, feedConfig config.SourceConfig, storage ArticleSaver) FetchResult {
+	result := FetchResult{Source: feedConfig.Name, FetchedAt: time.Now()}
+
+	feed, hub, err := getFeed(ctx, clientFor(feedConfig), feedConfig.FeedUrl, feedConfig.Timeout)
 	if err != nil {
-		log.Printf("Error: %v", err)
-		return
+		result.Err = err
+		return result
+	}
+	result.Hub = hub
+	result.ItemsSeen = len(feed.Items)
+	result.Feed = &FeedInfo{Title: feed.Title, Description: feed.Description, Link: feed.Link}
+	if feed.Image != nil {
+		result.Feed.Image = feed.Image.URL
 	}
 
 	articles, err := ExtractArticles(feed, feedConfig.Name)
 	if err != nil {
-		log.Printf("Error: %v", err)
+		result.Err = err
+		return result
+	}
+
+	result.Saved, result.Err = storage.SaveArticles(ctx, articles)
+	return result
+}
+
+// logFetchResult reports outcome of FetchSource
+func logFetchResult(result FetchResult) {
+	if result.Err != nil {
+		log.Printf("Error: %s: %v", result.Source, result.Err)
 		return
 	}
 
-	err = storage.SaveArticles(ctx, articles)
-	if err != nil {
-		log.Printf("Error: %v", err)
+	log.Printf("Saved %s: %s", result.Source, result.Saved)
+	for _, r := range result.Saved.Rejected {
+		log.Printf("Rejected %s: %s", r.Article.Url, r.Reason)
 	}
 }
 
+func processFeed(ctx context.Context, feedConfig config.SourceConfig, storage ArticleSaver) FetchResult {
+	log.Printf("Getting %s", feedConfig.FeedUrl)
+	result := FetchSource(ctx, feedConfig, storage)
+	logFetchResult(result)
+	return result
+}
+
 func ProcessFeeds(ctx context.Context, feedGroups map[string][]config.SourceConfig, storage ArticleSaver, continuous bool) {
+	if continuous {
+		collector := NewCollector(storage)
+		sources := make([]config.SourceConfig, 0)
+		for groupName := range feedGroups {
+			sources = append(sources, feedGroups[groupName]...)
+		}
+		collector.Apply(ctx, sources)
+		collector.Wait(ctx)
+		return
+	}
+
 	wg := sync.WaitGroup{}
 
 	for groupName := range feedGroups {
@@ -98,22 +352,6 @@ func ProcessFeeds(ctx context.Context, feedGroups map[string][]config.SourceConf
 			go func(feedConfig config.SourceConfig) {
 				defer wg.Done()
 				processFeed(ctx, feedConfig, storage)
-
-				if continuous {
-					ticker := time.NewTicker(feedConfig.UpdatePeriod)
-					defer ticker.Stop()
-
-					for {
-						select {
-						case <-ctx.Done():
-							log.Printf("%s collect loop terminating", feedConfig.Name)
-							return
-						case <-ticker.C:
-							processFeed(ctx, feedConfig, storage)
-						}
-					}
-				}
-
 			}(feedConfig)
 		}
 	}
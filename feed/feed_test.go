@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/comfyprog/allnews/config"
+	"github.com/mmcdole/gofeed"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -83,6 +84,35 @@ func TestGetFeedUnparseable(t *testing.T) {
 	assert.Nil(t, feed)
 }
 
+func TestFetchSourcePublicOnly(t *testing.T) {
+	data, err := os.ReadFile("./testdata/rss1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("content-type", "text/xml;charset=UTF-8")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	source := config.SourceConfig{Name: "local", FeedUrl: srv.URL, Timeout: time.Second}
+	result := fetchSource(context.Background(), source, newTestStorage())
+	assert.Nil(t, result.Err)
+	assert.Equal(t, 2, result.ItemsSeen)
+
+	// the test server listens on a loopback address
+	source.PublicOnly = true
+	result = fetchSource(context.Background(), source, newTestStorage())
+	assert.ErrorIs(t, result.Err, ErrNonPublicAddress)
+
+	assert.ErrorIs(t, refuseNonPublic("tcp", "10.0.0.1:80", nil), ErrNonPublicAddress)
+	assert.ErrorIs(t, refuseNonPublic("tcp6", "[fe80::1]:443", nil), ErrNonPublicAddress)
+	assert.Nil(t, refuseNonPublic("tcp", "93.184.216.34:443", nil))
+
+	redirect, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/", nil)
+	assert.NotNil(t, publicClient.CheckRedirect(redirect, []*http.Request{{}}))
+}
+
 func TestExtractArticles(t *testing.T) {
 	data, err := os.ReadFile("./testdata/rss1.xml")
 	if err != nil {
@@ -112,6 +142,56 @@ func TestExtractArticles(t *testing.T) {
 
 }
 
+func TestExtractArticlesUrls(t *testing.T) {
+	parsed, err := gofeed.NewParser().ParseString(`<rss version="2.0"><channel><title>t</title><link>https://example.com/news/</link>
+<item><title>ok</title><link>https://example.com/1</link><pubDate>Fri, 01 Mar 2024 12:00:00 GMT</pubDate></item>
+<item><title>script</title><link>javascript:alert(document.cookie)</link><pubDate>Fri, 01 Mar 2024 12:00:00 GMT</pubDate></item>
+<item><title>spaced</title><link> JavaScript:alert(1)</link><pubDate>Fri, 01 Mar 2024 12:00:00 GMT</pubDate></item>
+<item><title>data</title><link>data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;</link><pubDate>Fri, 01 Mar 2024 12:00:00 GMT</pubDate></item>
+<item><title>relative</title><link>/2</link><pubDate>Fri, 01 Mar 2024 12:00:00 GMT</pubDate></item>
+<item><title>relative to page</title><link>3.html</link><pubDate>Fri, 01 Mar 2024 12:00:00 GMT</pubDate></item>
+</channel></rss>`)
+	assert.Nil(t, err)
+
+	articles, err := ExtractArticles(parsed, "site1")
+	assert.Nil(t, err)
+	urls := make([]string, 0, len(articles))
+	for _, a := range articles {
+		urls = append(urls, a.Url)
+	}
+	assert.Equal(t, []string{"https://example.com/1", "https://example.com/2", "https://example.com/news/3.html"}, urls)
+
+	// without a site link relative links are kept as they are
+	parsed.Link = ""
+	articles, err = ExtractArticles(parsed, "site1")
+	assert.Nil(t, err)
+	if assert.Len(t, articles, 3) {
+		assert.Equal(t, "/2", articles[1].Url)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	a := Article{Title: "title", Description: "description"}
+	b := Article{Title: "title", Description: "description", Url: "example.com"}
+	c := Article{Title: "title", Description: "updated description"}
+
+	assert.Len(t, a.ContentHash(), 64)
+	assert.Equal(t, a.ContentHash(), b.ContentHash())
+	assert.NotEqual(t, a.ContentHash(), c.ContentHash())
+	assert.NotEqual(t, Article{Title: "ab", Description: "c"}.ContentHash(), Article{Title: "a", Description: "bc"}.ContentHash())
+}
+
+func TestSaveResult(t *testing.T) {
+	r := SaveResult{Inserted: 1, Duplicates: 2}
+	r.Add(SaveResult{Inserted: 3, Updated: 1, Rejected: []RejectedArticle{{Reason: "too long"}}})
+
+	assert.Equal(t, 4, r.Inserted)
+	assert.Equal(t, 1, r.Updated)
+	assert.Equal(t, 2, r.Duplicates)
+	assert.Len(t, r.Rejected, 1)
+	assert.Equal(t, "4 new, 1 updated, 2 duplicates, 1 rejected", r.String())
+}
+
 type testStorage struct {
 	articles []Article
 }
@@ -122,9 +202,9 @@ func newTestStorage() *testStorage {
 	return &s
 }
 
-func (s *testStorage) SaveArticles(ctx context.Context, articles []Article) error {
+func (s *testStorage) SaveArticles(ctx context.Context, articles []Article) (SaveResult, error) {
 	s.articles = append(s.articles, articles...)
-	return nil
+	return SaveResult{Inserted: len(articles)}, nil
 }
 
 func TestProcessFeeds(t *testing.T) {
@@ -0,0 +1,525 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	SubscriptionPending = "pending"
+	SubscriptionActive  = "active"
+	SubscriptionDenied  = "denied"
+	SubscriptionFailed  = "failed"
+
+	// WebSubCallbackPath is where the http server takes verifications and content from hubs,
+	// followed by the source name
+	WebSubCallbackPath = "/websub/"
+
+	// subscriptionRetryInterval is how long to wait before asking a hub again
+	// after it failed, denied the subscription or didn't verify it
+	subscriptionRetryInterval = time.Hour
+	hubRequestTimeout         = time.Second * 30
+	// verificationWindow is how long after a request a hub may verify
+	// a subscription that is already active again
+	verificationWindow = time.Minute * 10
+)
+
+var (
+	ErrNoSubscription = errors.New("no subscription")
+	// ErrBadSignature means that pushed content wasn't signed with the subscription secret
+	ErrBadSignature = errors.New("bad signature of pushed content")
+	ErrBadContent   = errors.New("pushed content is not a feed")
+)
+
+// HubLinks are WebSub links advertised by a feed
+type HubLinks struct {
+	Hub string
+	// Self is the canonical feed url subscriptions are made for
+	Self string
+}
+
+// parseLinkHeader returns the first url for every rel of Link headers like <url>; rel="hub"
+func parseLinkHeader(values []string) map[string]string {
+	links := make(map[string]string)
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			start, end := strings.Index(link, "<"), strings.Index(link, ">")
+			if start < 0 || end < start {
+				continue
+			}
+			href := strings.TrimSpace(link[start+1 : end])
+			for _, param := range strings.Split(link[end+1:], ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+					rel = strings.ToLower(rel)
+					if _, ok := links[rel]; !ok {
+						links[rel] = href
+					}
+				}
+			}
+		}
+	}
+	return links
+}
+
+// discoverHub finds hub and self links in http headers or in the feed-level links of an atom or rss document.
+// Headers take precedence.
+func discoverHub(header http.Header, body []byte) HubLinks {
+	links := parseLinkHeader(header.Values("Link"))
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	// only attributes of link elements are needed, they are ascii in practice
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if element.Name.Local == "item" || element.Name.Local == "entry" {
+			break
+		}
+		if element.Name.Local != "link" {
+			continue
+		}
+
+		var rel, href string
+		for _, attr := range element.Attr {
+			switch attr.Name.Local {
+			case "rel":
+				rel = strings.ToLower(attr.Value)
+			case "href":
+				href = strings.TrimSpace(attr.Value)
+			}
+		}
+		if _, ok := links[rel]; !ok && href != "" {
+			links[rel] = href
+		}
+	}
+
+	return HubLinks{Hub: links["hub"], Self: links["self"]}
+}
+
+// Subscription is a WebSub subscription of a source to updates of its feed
+type Subscription struct {
+	Source string
+	Hub    string
+	Topic  string
+	Secret string
+	State  string
+	// Lease is the duration granted by the hub, ExpiresAt is nil until the hub verifies the subscription
+	Lease       time.Duration
+	ExpiresAt   *time.Time
+	RequestedAt time.Time
+	Error       string
+}
+
+// SubscriptionStore keeps subscriptions, so that hubs can be answered by another process
+// than the one that subscribed
+type SubscriptionStore interface {
+	// GetSubscription returns ErrNoSubscription if the source isn't subscribed
+	GetSubscription(ctx context.Context, source string) (Subscription, error)
+	SaveSubscription(ctx context.Context, sub Subscription) error
+	DeleteSubscription(ctx context.Context, source string) error
+}
+
+type WebSubStorage interface {
+	ArticleSaver
+	SubscriptionStore
+}
+
+// WebSub subscribes sources to hubs their feeds advertise and takes content pushed by hubs.
+// Sources with active subscriptions are still polled, only less often.
+type WebSub struct {
+	storage     WebSubStorage
+	callbackUrl string
+	lease       time.Duration
+	fallback    time.Duration
+	privateHubs bool
+	client      *http.Client
+	sources     func() []config.SourceConfig
+}
+
+type WebSubOption func(*WebSub)
+
+// WithSources makes WebSub refuse content pushed for sources that aren't
+// among the ones sources returns, like removed or disabled ones
+func WithSources(sources func() []config.SourceConfig) WebSubOption {
+	return func(w *WebSub) {
+		w.sources = sources
+	}
+}
+
+func NewWebSub(storage WebSubStorage, cfg config.WebSubConfig, options ...WebSubOption) *WebSub {
+	client := &http.Client{Timeout: hubRequestTimeout}
+	if !cfg.AllowPrivateHubs {
+		client.Transport = publicClient.Transport
+		client.CheckRedirect = publicClient.CheckRedirect
+	}
+	w := &WebSub{
+		storage:     storage,
+		callbackUrl: strings.TrimSuffix(cfg.CallbackUrl, "/") + WebSubCallbackPath,
+		lease:       cfg.Lease,
+		fallback:    cfg.FallbackUpdate,
+		privateHubs: cfg.AllowPrivateHubs,
+		client:      client,
+	}
+	for _, f := range options {
+		f(w)
+	}
+	return w
+}
+
+// configured reports whether the source is currently configured and enabled
+func (w *WebSub) configured(source string) bool {
+	if w.sources == nil {
+		return true
+	}
+	for _, s := range w.sources() {
+		if s.Name == source {
+			return true
+		}
+	}
+	return false
+}
+
+// validHub checks the hub address advertised by the feed before it's asked to subscribe
+func (w *WebSub) validHub(hub string) error {
+	if w.privateHubs {
+		u, err := url.Parse(hub)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("hub %q has to be an http or https url", hub)
+		}
+		return nil
+	}
+	if err := config.ValidatePublicFeedUrl(hub); err != nil {
+		return fmt.Errorf("hub: %w", err)
+	}
+	return nil
+}
+
+func (w *WebSub) callback(source string) string {
+	return w.callbackUrl + url.PathEscape(source)
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// renewMargin is how long before expiration a subscription is renewed
+func (w *WebSub) renewMargin(sub Subscription) time.Duration {
+	if sub.Lease > 0 {
+		return sub.Lease / 10
+	}
+	return w.lease / 10
+}
+
+// needsRequest reports whether the hub has to be asked to subscribe again
+func (w *WebSub) needsRequest(sub Subscription, now time.Time) bool {
+	if sub.State == SubscriptionActive {
+		return sub.ExpiresAt == nil || sub.ExpiresAt.Sub(now) < w.renewMargin(sub)
+	}
+	return now.Sub(sub.RequestedAt) > subscriptionRetryInterval
+}
+
+// Check subscribes the source after it was fetched if its feed advertises a hub, and renews
+// subscriptions that are about to expire. Subscriptions of feeds that no longer advertise a hub are dropped.
+func (w *WebSub) Check(ctx context.Context, source config.SourceConfig, result FetchResult) {
+	sub, err := w.storage.GetSubscription(ctx, source.Name)
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrNoSubscription) {
+		log.Printf("Error: getting subscription of %s: %v", source.Name, err)
+		return
+	}
+
+	// links are unknown if the fetch failed, but the existing subscription is kept alive
+	if result.Err != nil {
+		if found && w.needsRequest(sub, time.Now()) {
+			w.subscribe(ctx, sub)
+		}
+		return
+	}
+
+	if result.Hub.Hub == "" {
+		if found {
+			log.Printf("%s no longer advertises a hub, dropping subscription", source.Name)
+			if err := w.storage.DeleteSubscription(ctx, source.Name); err != nil {
+				log.Printf("Error: deleting subscription of %s: %v", source.Name, err)
+			}
+		}
+		return
+	}
+
+	topic := result.Hub.Self
+	if topic == "" {
+		topic = source.FeedUrl
+	}
+	if found && sub.Hub == result.Hub.Hub && sub.Topic == topic {
+		if w.needsRequest(sub, time.Now()) {
+			w.subscribe(ctx, sub)
+		}
+		return
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		log.Printf("Error: subscribing %s: %v", source.Name, err)
+		return
+	}
+	w.subscribe(ctx, Subscription{Source: source.Name, Hub: result.Hub.Hub, Topic: topic, Secret: secret})
+}
+
+// subscribe asks the hub for a subscription, which becomes active when the hub verifies it
+func (w *WebSub) subscribe(ctx context.Context, sub Subscription) {
+	log.Printf("Subscribing %s to %s at %s", sub.Source, sub.Topic, sub.Hub)
+
+	// the hub may verify the subscription before it responds
+	sub.State = SubscriptionPending
+	sub.RequestedAt = time.Now()
+	sub.Error = ""
+	if err := w.storage.SaveSubscription(ctx, sub); err != nil {
+		log.Printf("Error: saving subscription of %s: %v", sub.Source, err)
+		return
+	}
+
+	if err := w.requestHub(ctx, sub, "subscribe"); err != nil {
+		log.Printf("Error: subscribing %s: %v", sub.Source, err)
+		sub.State = SubscriptionFailed
+		sub.Error = err.Error()
+		if err := w.storage.SaveSubscription(ctx, sub); err != nil {
+			log.Printf("Error: saving subscription of %s: %v", sub.Source, err)
+		}
+	}
+}
+
+// Unsubscribe drops the subscription of a source that was removed or disabled
+// and asks the hub to stop pushing its content
+func (w *WebSub) Unsubscribe(ctx context.Context, source string) {
+	sub, err := w.storage.GetSubscription(ctx, source)
+	if errors.Is(err, ErrNoSubscription) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error: getting subscription of %s: %v", source, err)
+		return
+	}
+
+	log.Printf("Unsubscribing %s from %s at %s", source, sub.Topic, sub.Hub)
+	// without the subscription pushes are refused even if the hub can't be reached
+	if err := w.storage.DeleteSubscription(ctx, source); err != nil {
+		log.Printf("Error: deleting subscription of %s: %v", source, err)
+		return
+	}
+	if err := w.requestHub(ctx, sub, "unsubscribe"); err != nil {
+		log.Printf("Error: unsubscribing %s: %v", source, err)
+	}
+}
+
+// requestHub asks the hub to subscribe or unsubscribe the source
+func (w *WebSub) requestHub(ctx context.Context, sub Subscription, mode string) error {
+	if err := w.validHub(sub.Hub); err != nil {
+		return err
+	}
+	form := url.Values{
+		"hub.callback": {w.callback(sub.Source)},
+		"hub.mode":     {mode},
+		"hub.topic":    {sub.Topic},
+	}
+	if mode == "subscribe" {
+		form.Set("hub.lease_seconds", strconv.Itoa(int(w.lease.Seconds())))
+		form.Set("hub.secret", sub.Secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("hub responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// PollPeriod returns time until the next fetch of the source: the fallback period if it's subscribed,
+// but no later than its subscription has to be renewed
+func (w *WebSub) PollPeriod(ctx context.Context, source config.SourceConfig) time.Duration {
+	sub, err := w.storage.GetSubscription(ctx, source.Name)
+	if err != nil || sub.State != SubscriptionActive || sub.ExpiresAt == nil {
+		return source.UpdatePeriod
+	}
+
+	untilRenewal := time.Until(*sub.ExpiresAt) - w.renewMargin(sub)
+	if untilRenewal <= 0 {
+		return source.UpdatePeriod
+	}
+	return max(min(max(source.UpdatePeriod, w.fallback), untilRenewal), time.Second)
+}
+
+// Verification is a request of a hub to confirm the intent of subscribing or unsubscribing,
+// or a notice that the subscription was denied
+type Verification struct {
+	Mode  string
+	Topic string
+	Lease time.Duration
+	// Reason is set when subscription is denied
+	Reason string
+}
+
+// expectsVerification reports whether a subscription was requested and can be confirmed
+// or denied by the hub, so that a forged verification can't activate a denied or failed
+// subscription, nor deny an active one
+func (w *WebSub) expectsVerification(sub Subscription, now time.Time) bool {
+	switch sub.State {
+	case SubscriptionPending:
+		return true
+	case SubscriptionActive:
+		return now.Sub(sub.RequestedAt) < verificationWindow
+	}
+	return false
+}
+
+// Verify reports whether the hub's request matches what the source wants.
+// A confirmed subscription becomes active, unsolicited confirmations are refused.
+func (w *WebSub) Verify(ctx context.Context, source string, v Verification) (bool, error) {
+	sub, err := w.storage.GetSubscription(ctx, source)
+	if errors.Is(err, ErrNoSubscription) {
+		return v.Mode == "unsubscribe", nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if sub.Topic != v.Topic {
+		return v.Mode == "unsubscribe", nil
+	}
+
+	switch v.Mode {
+	case "subscribe":
+		if !w.expectsVerification(sub, time.Now()) {
+			log.Printf("Unsolicited verification of %s subscription refused", source)
+			return false, nil
+		}
+		sub.State = SubscriptionActive
+		sub.Lease = v.Lease
+		if sub.Lease <= 0 {
+			sub.Lease = w.lease
+		}
+		expires := time.Now().Add(sub.Lease)
+		sub.ExpiresAt = &expires
+		sub.Error = ""
+		log.Printf("Subscription of %s verified for %s", source, sub.Lease)
+	case "denied":
+		if !w.expectsVerification(sub, time.Now()) {
+			log.Printf("Unsolicited denial of %s subscription refused", source)
+			return false, nil
+		}
+		sub.State = SubscriptionDenied
+		sub.Error = v.Reason
+		log.Printf("Subscription of %s denied: %s", source, v.Reason)
+	default:
+		return false, nil
+	}
+	return true, w.storage.SaveSubscription(ctx, sub)
+}
+
+// validSignature checks X-Hub-Signature header like sha256=hex of body content
+func validSignature(secret string, signature string, body []byte) bool {
+	method, sum, ok := strings.Cut(signature, "=")
+	if !ok || secret == "" {
+		return false
+	}
+
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Receive saves articles of content pushed by the hub the source is subscribed to.
+// Sources that were removed or disabled are reported as not subscribed.
+func (w *WebSub) Receive(ctx context.Context, source string, signature string, body []byte) (SaveResult, error) {
+	if !w.configured(source) {
+		return SaveResult{}, fmt.Errorf("%s is not an enabled source: %w", source, ErrNoSubscription)
+	}
+	sub, err := w.storage.GetSubscription(ctx, source)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	if !validSignature(sub.Secret, signature, body) {
+		return SaveResult{}, ErrBadSignature
+	}
+
+	pushed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("%w: %v", ErrBadContent, err)
+	}
+	articles, err := ExtractArticles(pushed, source)
+	if err != nil {
+		return SaveResult{}, err
+	}
+
+	result, err := w.storage.SaveArticles(ctx, articles)
+	if err == nil {
+		log.Printf("Pushed %s: %s", source, result)
+	}
+	return result, err
+}
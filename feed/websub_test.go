@@ -0,0 +1,253 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverHub(t *testing.T) {
+	atom := `<?xml version="1.0" encoding="windows-1251"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <link rel="alternate" href="https://example.com/"/>
+  <link rel="hub" href="https://hub.example.com/"/>
+  <link rel="self" href="https://example.com/feed.atom"/>
+  <entry><link rel="self" href="https://example.com/entry"/></entry>
+</feed>`
+	rss := `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+  <link>https://example.com/</link>
+  <item><atom:link rel="hub" href="https://item-hub.example.com/"/></item>
+  <atom:link rel="hub" href="https://late-hub.example.com/"/>
+</channel></rss>`
+
+	assert.Equal(t, HubLinks{Hub: "https://hub.example.com/", Self: "https://example.com/feed.atom"},
+		discoverHub(http.Header{}, []byte(atom)))
+	assert.Equal(t, HubLinks{}, discoverHub(http.Header{}, []byte(rss)))
+
+	header := http.Header{}
+	header.Add("Link", `<https://header-hub.example.com/>; rel="hub", <https://example.com/feed>; rel="self canonical"`)
+	assert.Equal(t, HubLinks{Hub: "https://header-hub.example.com/", Self: "https://example.com/feed"},
+		discoverHub(header, []byte(atom)))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte("The quick brown fox jumps over the lazy dog")
+
+	assert.True(t, validSignature("key", "sha1=de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9", body))
+	assert.True(t, validSignature("key", "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", body))
+	assert.False(t, validSignature("other", "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", body))
+	assert.False(t, validSignature("key", "md5=80070713463e7749b90c2dc24911e275", body))
+	assert.False(t, validSignature("key", "sha256=not hex", body))
+	assert.False(t, validSignature("", "sha256=abc", body))
+}
+
+type testSubscriptions struct {
+	testStorage
+	mu            sync.Mutex
+	subscriptions map[string]Subscription
+}
+
+func (s *testSubscriptions) GetSubscription(ctx context.Context, source string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[source]
+	if !ok {
+		return sub, ErrNoSubscription
+	}
+	return sub, nil
+}
+
+func (s *testSubscriptions) SaveSubscription(ctx context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.Source] = sub
+	return nil
+}
+
+func (s *testSubscriptions) DeleteSubscription(ctx context.Context, source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, source)
+	return nil
+}
+
+func TestWebSubVerify(t *testing.T) {
+	storage := &testSubscriptions{subscriptions: make(map[string]Subscription)}
+	websub := NewWebSub(storage, config.WebSubConfig{CallbackUrl: "https://news.example.com/", Lease: time.Hour})
+	ctx := context.Background()
+	v := Verification{Mode: "subscribe", Topic: "https://example.com/feed", Lease: time.Hour * 2}
+
+	ok, err := websub.Verify(ctx, "example", v)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, storage.subscriptions)
+
+	for _, state := range []string{SubscriptionFailed, SubscriptionDenied} {
+		storage.subscriptions["example"] = Subscription{Source: "example", Topic: v.Topic, State: state, RequestedAt: time.Now()}
+		ok, err = websub.Verify(ctx, "example", v)
+		assert.Nil(t, err)
+		assert.False(t, ok, state)
+		assert.Equal(t, state, storage.subscriptions["example"].State)
+	}
+
+	// active subscription is only confirmed or denied shortly after it was requested
+	storage.subscriptions["example"] = Subscription{Source: "example", Topic: v.Topic, State: SubscriptionActive, RequestedAt: time.Now().Add(-time.Hour)}
+	ok, err = websub.Verify(ctx, "example", v)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, time.Duration(0), storage.subscriptions["example"].Lease)
+
+	ok, err = websub.Verify(ctx, "example", Verification{Mode: "denied", Topic: v.Topic, Reason: "forged"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, SubscriptionActive, storage.subscriptions["example"].State)
+
+	storage.subscriptions["example"] = Subscription{Source: "example", Topic: v.Topic, State: SubscriptionActive, RequestedAt: time.Now()}
+	ok, err = websub.Verify(ctx, "example", v)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	storage.subscriptions["example"] = Subscription{Source: "example", Topic: v.Topic, State: SubscriptionPending, RequestedAt: time.Now()}
+	ok, err = websub.Verify(ctx, "example", v)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, SubscriptionActive, storage.subscriptions["example"].State)
+	assert.Equal(t, time.Hour*2, storage.subscriptions["example"].Lease)
+
+	storage.subscriptions["example"] = Subscription{Source: "example", Topic: v.Topic, State: SubscriptionPending, RequestedAt: time.Now()}
+	ok, err = websub.Verify(ctx, "example", Verification{Mode: "denied", Topic: v.Topic, Reason: "spam"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, SubscriptionDenied, storage.subscriptions["example"].State)
+}
+
+func TestWebSubCheck(t *testing.T) {
+	requests := 0
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "not today", http.StatusServiceUnavailable)
+	}))
+	defer hub.Close()
+
+	storage := &testSubscriptions{subscriptions: make(map[string]Subscription)}
+	websub := NewWebSub(storage, config.WebSubConfig{CallbackUrl: "https://news.example.com/", Lease: time.Hour * 10, FallbackUpdate: time.Hour})
+	source := config.SourceConfig{Name: "example", FeedUrl: "https://example.com/feed", UpdatePeriod: time.Minute}
+	ctx := context.Background()
+	fetched := FetchResult{Source: "example", Hub: HubLinks{Hub: hub.URL}}
+
+	// hubs advertised by feeds can't be on internal addresses unless allowed
+	for _, hubUrl := range []string{hub.URL, "http://169.254.169.254/", "ftp://example.com/hub"} {
+		websub.Check(ctx, source, FetchResult{Source: "example", Hub: HubLinks{Hub: hubUrl}})
+		assert.Equal(t, 0, requests)
+		assert.Equal(t, SubscriptionFailed, storage.subscriptions["example"].State, hubUrl)
+		delete(storage.subscriptions, "example")
+	}
+
+	websub = NewWebSub(storage, config.WebSubConfig{CallbackUrl: "https://news.example.com/", Lease: time.Hour * 10, FallbackUpdate: time.Hour, AllowPrivateHubs: true})
+	websub.Check(ctx, source, FetchResult{Source: "example", Hub: HubLinks{Hub: "ftp://example.com/hub"}})
+	assert.Equal(t, SubscriptionFailed, storage.subscriptions["example"].State)
+	delete(storage.subscriptions, "example")
+
+	websub.Check(ctx, source, fetched)
+	assert.Equal(t, 1, requests)
+	sub := storage.subscriptions["example"]
+	assert.Equal(t, SubscriptionFailed, sub.State)
+	assert.Equal(t, "https://example.com/feed", sub.Topic)
+	assert.Contains(t, sub.Error, "not today")
+	assert.Equal(t, "https://news.example.com/websub/example", websub.callback("example"))
+
+	// the hub isn't asked again right away
+	websub.Check(ctx, source, fetched)
+	assert.Equal(t, 1, requests)
+
+	// active subscription is renewed when it's about to expire, even if the feed is down
+	expires := time.Now().Add(time.Hour * 3)
+	sub.State = SubscriptionActive
+	sub.ExpiresAt = &expires
+	sub.Lease = time.Hour * 10
+	storage.subscriptions["example"] = sub
+	assert.Equal(t, time.Hour, websub.PollPeriod(ctx, source))
+
+	expires = time.Now().Add(time.Minute * 30)
+	sub.ExpiresAt = &expires
+	storage.subscriptions["example"] = sub
+	websub.Check(ctx, source, FetchResult{Source: "example", Err: errors.New("timeout")})
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, sub.Secret, storage.subscriptions["example"].Secret)
+
+	// subscription is dropped when the hub isn't advertised anymore
+	websub.Check(ctx, source, FetchResult{Source: "example"})
+	assert.Empty(t, storage.subscriptions)
+	assert.Equal(t, time.Minute, websub.PollPeriod(ctx, source))
+}
+
+func TestWebSubUnsubscribe(t *testing.T) {
+	requests := make(chan url.Values, 2)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests <- r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	storage := &testSubscriptions{subscriptions: make(map[string]Subscription)}
+	expires := time.Now().Add(time.Hour * 24)
+	for _, name := range []string{"removed", "kept"} {
+		storage.subscriptions[name] = Subscription{Source: name, Hub: hub.URL, Topic: "https://example.com/" + name,
+			Secret: "secret", State: SubscriptionActive, Lease: time.Hour * 24, ExpiresAt: &expires}
+	}
+	removed := config.SourceConfig{Name: "removed", FeedUrl: "https://example.com/removed", UpdatePeriod: time.Hour}
+	kept := config.SourceConfig{Name: "kept", FeedUrl: "https://example.com/kept", UpdatePeriod: time.Hour}
+
+	var mu sync.Mutex
+	sources := []config.SourceConfig{removed, kept}
+	websub := NewWebSub(storage, config.WebSubConfig{CallbackUrl: "https://news.example.com/", Lease: time.Hour * 24, AllowPrivateHubs: true},
+		WithSources(func() []config.SourceConfig {
+			mu.Lock()
+			defer mu.Unlock()
+			return sources
+		}))
+	collector := NewCollector(storage, WithWebSub(websub))
+	collector.fetch = func(ctx context.Context, source config.SourceConfig, storage ArticleSaver) FetchResult {
+		return FetchResult{Source: source.Name, Err: errors.New("down")}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	collector.Apply(ctx, sources)
+	mu.Lock()
+	sources = []config.SourceConfig{kept}
+	mu.Unlock()
+	collector.Apply(ctx, sources)
+
+	select {
+	case form := <-requests:
+		assert.Equal(t, "unsubscribe", form.Get("hub.mode"))
+		assert.Equal(t, "https://example.com/removed", form.Get("hub.topic"))
+		assert.Equal(t, "https://news.example.com/websub/removed", form.Get("hub.callback"))
+	case <-time.After(time.Second * 5):
+		t.Error("hub wasn't asked to unsubscribe")
+	}
+	_, err := storage.GetSubscription(ctx, "removed")
+	assert.ErrorIs(t, err, ErrNoSubscription)
+	_, err = storage.GetSubscription(ctx, "kept")
+	assert.Nil(t, err)
+
+	// content pushed for a source that isn't configured anymore is refused even with a subscription
+	storage.SaveSubscription(ctx, Subscription{Source: "removed", Hub: hub.URL, Topic: "https://example.com/removed", Secret: "secret"})
+	_, err = websub.Receive(ctx, "removed", "", []byte("<rss/>"))
+	assert.ErrorIs(t, err, ErrNoSubscription)
+	_, err = websub.Receive(ctx, "kept", "", []byte("<rss/>"))
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	cancel()
+	collector.Wait(ctx)
+	assert.Empty(t, requests)
+}
@@ -0,0 +1,149 @@
+package feed
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	// JobDead is the state of jobs that failed max attempts times
+	JobDead = "dead"
+)
+
+// FetchJob is a queued fetch of a source
+type FetchJob struct {
+	ID          int64
+	Source      string
+	State       string
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	LockedBy    string
+	LastError   string
+	CreatedAt   time.Time
+	FinishedAt  *time.Time
+}
+
+type JobQueue interface {
+	// ClaimJobs takes at most limit jobs that are due, hiding them from other workers
+	ClaimJobs(ctx context.Context, limit int) ([]FetchJob, error)
+	CompleteJob(ctx context.Context, id int64) error
+	// FailJob schedules the job to be retried after given time, or marks it dead
+	// if it has no attempts left
+	FailJob(ctx context.Context, id int64, reason string, retryAfter time.Duration) error
+}
+
+// SourceLookup returns config of the source with given name
+type SourceLookup func(name string) (config.SourceConfig, bool)
+
+// Worker runs fetch jobs from the queue
+type Worker struct {
+	queue        JobQueue
+	storage      ArticleSaver
+	lookup       SourceLookup
+	concurrency  int
+	pollInterval time.Duration
+	fetch        func(context.Context, config.SourceConfig, ArticleSaver) FetchResult
+}
+
+func NewWorker(queue JobQueue, storage ArticleSaver, lookup SourceLookup, concurrency int, pollInterval time.Duration) *Worker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Worker{
+		queue:        queue,
+		storage:      storage,
+		lookup:       lookup,
+		concurrency:  concurrency,
+		pollInterval: pollInterval,
+		fetch:        FetchSource,
+	}
+}
+
+// retryBackoff doubles the delay with every attempt, up to an hour
+func retryBackoff(attempts int) time.Duration {
+	delay := time.Second * 30
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+func (w *Worker) run(ctx context.Context, job FetchJob) {
+	// job state has to be saved even if the worker is stopping
+	reportCtx := context.WithoutCancel(ctx)
+
+	source, ok := w.lookup(job.Source)
+	if !ok {
+		log.Printf("Error: job %d: source %s is not configured", job.ID, job.Source)
+		if err := w.queue.FailJob(reportCtx, job.ID, "source is not configured", retryBackoff(job.Attempts)); err != nil {
+			log.Printf("Error: job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Getting %s (job %d, attempt %d of %d)", source.FeedUrl, job.ID, job.Attempts, job.MaxAttempts)
+	result := w.fetch(ctx, source, w.storage)
+	logFetchResult(result)
+
+	var err error
+	if result.Err != nil {
+		err = w.queue.FailJob(reportCtx, job.ID, result.Err.Error(), retryBackoff(job.Attempts))
+	} else {
+		err = w.queue.CompleteJob(reportCtx, job.ID)
+	}
+	if err != nil {
+		log.Printf("Error: job %d: %v", job.ID, err)
+	}
+}
+
+// Run claims and runs jobs until ctx is done, then waits for running jobs to finish
+func (w *Worker) Run(ctx context.Context) {
+	slots := make(chan struct{}, w.concurrency)
+	finished := make(chan struct{}, 1)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if free := w.concurrency - len(slots); free > 0 {
+			jobs, err := w.queue.ClaimJobs(ctx, free)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error: claiming jobs: %v", err)
+			}
+
+			for _, job := range jobs {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(job FetchJob) {
+					defer wg.Done()
+					w.run(ctx, job)
+					<-slots
+					select {
+					case finished <- struct{}{}:
+					default:
+					}
+				}(job)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-finished:
+		}
+	}
+}
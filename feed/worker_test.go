@@ -0,0 +1,105 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type testQueue struct {
+	mu     sync.Mutex
+	jobs   []FetchJob
+	states map[int64]string
+	delays map[int64]time.Duration
+}
+
+func (q *testQueue) ClaimJobs(ctx context.Context, limit int) ([]FetchJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit > len(q.jobs) {
+		limit = len(q.jobs)
+	}
+	claimed := q.jobs[:limit]
+	q.jobs = q.jobs[limit:]
+	for i := range claimed {
+		claimed[i].Attempts++
+		q.states[claimed[i].ID] = JobRunning
+	}
+	return claimed, nil
+}
+
+func (q *testQueue) CompleteJob(ctx context.Context, id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.states[id] = JobDone
+	return nil
+}
+
+func (q *testQueue) FailJob(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.states[id] = JobPending
+	q.delays[id] = retryAfter
+	return nil
+}
+
+func (q *testQueue) state(id int64) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.states[id]
+}
+
+func TestWorker(t *testing.T) {
+	queue := &testQueue{
+		jobs: []FetchJob{
+			{ID: 1, Source: "good"},
+			{ID: 2, Source: "bad", Attempts: 2},
+			{ID: 3, Source: "unknown"},
+			{ID: 4, Source: "good"},
+		},
+		states: make(map[int64]string),
+		delays: make(map[int64]time.Duration),
+	}
+
+	lookup := func(name string) (config.SourceConfig, bool) {
+		if name == "unknown" {
+			return config.SourceConfig{}, false
+		}
+		return config.SourceConfig{Name: name}, true
+	}
+
+	worker := NewWorker(queue, newTestStorage(), lookup, 2, time.Hour)
+	worker.fetch = func(ctx context.Context, source config.SourceConfig, storage ArticleSaver) FetchResult {
+		result := FetchResult{Source: source.Name}
+		if source.Name == "bad" {
+			result.Err = errors.New("feed is broken")
+		}
+		return result
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+	// jobs are claimed again as soon as slots are free, without waiting for poll interval
+	worker.Run(ctx)
+
+	assert.Equal(t, JobDone, queue.state(1))
+	assert.Equal(t, JobPending, queue.state(2))
+	assert.Equal(t, time.Minute*2, queue.delays[2])
+	assert.Equal(t, JobPending, queue.state(3))
+	assert.Equal(t, JobDone, queue.state(4))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Second*30, retryBackoff(1))
+	assert.Equal(t, time.Minute, retryBackoff(2))
+	assert.Equal(t, time.Hour, retryBackoff(20))
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie     = "allnews_session"
+	userKey           = "user"
+	minPasswordLength = 8
+)
+
+type User struct {
+	ID           int64     `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Admin        bool      `json:"admin"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type UserStorage interface {
+	GetUserByName(ctx context.Context, username string) (User, error)
+	CreateSession(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	GetSessionUser(ctx context.Context, tokenHash string) (User, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+}
+
+// HashPassword returns bcrypt hash of the password to be stored with the user
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password has to be at least %d characters long", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// dummyHash is compared with passwords of unknown users,
+// so that response time doesn't tell which usernames exist
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("allnews"), bcrypt.DefaultCost)
+	return hash
+})
+
+// newSessionToken returns a random token for the cookie and its hash for storage,
+// so that leaked sessions table can't be used to log in
+func newSessionToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashSessionToken(token), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// setSessionCookie sets the cookie or removes it if maxAge is negative.
+// The cookie is secure when the site is served over https, directly, by public_url
+// or as told by a TLS-terminating proxy. A forged header can only make it stricter.
+func setSessionCookie(c *gin.Context, cfg ConfigGetter, token string, maxAge int) {
+	secure := c.Request.TLS != nil ||
+		strings.HasPrefix(cfg.Get().Server.PublicUrl, "https://") ||
+		strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+}
+
+// authenticate attaches the user logged in with the session cookie to the context
+func authenticate(db UserStorage, cfg ConfigGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := c.Cookie(sessionCookie)
+		if err != nil || token == "" {
+			c.Next()
+			return
+		}
+
+		user, err := db.GetSessionUser(c.Request.Context(), hashSessionToken(token))
+		switch {
+		case err == nil:
+			c.Set(userKey, &user)
+		case errors.Is(err, ErrNotFound):
+			setSessionCookie(c, cfg, "", -1)
+		default:
+			log.Printf("Error: getting session user: %v", err)
+		}
+		c.Next()
+	}
+}
+
+// currentUser returns the logged in user or nil for anonymous visitors
+func currentUser(c *gin.Context) *User {
+	user, _ := c.Get(userKey)
+	u, _ := user.(*User)
+	return u
+}
+
+// requireReader lets anonymous visitors through unless login is required by config.
+// Pages redirect to the login form, api and feeds respond with 401.
+func requireReader(cfg ConfigGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.Get().Auth.RequireLogin || currentUser(c) != nil {
+			c.Next()
+			return
+		}
+
+		path := c.Request.URL.Path
+		if strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/feeds/") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/login?"+url.Values{"next": {c.Request.URL.RequestURI()}}.Encode())
+		c.Abort()
+	}
+}
+
+// requireAdmin lets only administrators through, it guards routes changing sources
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+			return
+		}
+		if !user.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// renderPage renders html template with the logged in user added to data for navigation
+func renderPage(c *gin.Context, status int, name string, data gin.H) {
+	data["User"] = currentUser(c)
+	c.HTML(status, name, data)
+}
+
+// localPath returns next if it's a path on this site, so that login can't redirect elsewhere
+func localPath(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return "/"
+	}
+	return next
+}
+
+func handleLoginPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		renderPage(c, http.StatusOK, "login.html", gin.H{
+			"Url":   c.Request.URL.Path,
+			"Title": "Log in",
+			"Next":  localPath(c.Query("next")),
+		})
+	}
+}
+
+func handleLogin(db UserStorage, cfg ConfigGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := strings.TrimSpace(c.PostForm("username"))
+		password := c.PostForm("password")
+		next := localPath(c.PostForm("next"))
+
+		showError := func(status int, message string) {
+			renderPage(c, status, "login.html", gin.H{
+				"Url":      c.Request.URL.Path,
+				"Title":    "Log in",
+				"Next":     next,
+				"Username": username,
+				"Error":    message,
+			})
+		}
+
+		user, err := db.GetUserByName(c.Request.Context(), username)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			showError(http.StatusInternalServerError, fmt.Sprintf("Error happened: %v", err))
+			return
+		}
+		known := err == nil
+		hash := []byte(user.PasswordHash)
+		if !known {
+			hash = dummyHash()
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !known {
+			showError(http.StatusUnauthorized, "Wrong username or password")
+			return
+		}
+
+		token, tokenHash, err := newSessionToken()
+		if err != nil {
+			showError(http.StatusInternalServerError, fmt.Sprintf("Error happened: %v", err))
+			return
+		}
+		ttl := cfg.Get().Auth.SessionTTL
+		if err := db.CreateSession(c.Request.Context(), user.ID, tokenHash, time.Now().Add(ttl)); err != nil {
+			showError(http.StatusInternalServerError, fmt.Sprintf("Error happened: %v", err))
+			return
+		}
+		setSessionCookie(c, cfg, token, int(ttl.Seconds()))
+
+		log.Printf("%s logged in from %s", user.Username, c.ClientIP())
+		c.Redirect(http.StatusSeeOther, next)
+	}
+}
+
+func handleLogout(db UserStorage, cfg ConfigGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, err := c.Cookie(sessionCookie); err == nil && token != "" {
+			if err := db.DeleteSession(c.Request.Context(), hashSessionToken(token)); err != nil {
+				log.Printf("Error: deleting session: %v", err)
+			}
+		}
+		setSessionCookie(c, cfg, "", -1)
+		c.Redirect(http.StatusSeeOther, "/")
+	}
+}
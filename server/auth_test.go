@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type userStorage struct {
+	mu       sync.Mutex
+	users    []User
+	sessions map[string]int64
+}
+
+func (s *userStorage) GetUserByName(ctx context.Context, username string) (User, error) {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Username, username) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *userStorage) CreateSession(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[tokenHash] = userID
+	return nil
+}
+
+func (s *userStorage) GetSessionUser(ctx context.Context, tokenHash string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.sessions[tokenHash]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	for _, u := range s.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *userStorage) DeleteSession(ctx context.Context, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func TestHashPassword(t *testing.T) {
+	_, err := HashPassword("short")
+	assert.NotNil(t, err)
+
+	hash, err := HashPassword("correct horse")
+	assert.Nil(t, err)
+	assert.NotContains(t, hash, "correct horse")
+}
+
+func TestLocalPath(t *testing.T) {
+	assert.Equal(t, "/search?filter=vote", localPath("/search?filter=vote"))
+	assert.Equal(t, "/", localPath(""))
+	assert.Equal(t, "/", localPath("https://evil.com/"))
+	assert.Equal(t, "/", localPath("//evil.com/"))
+	assert.Equal(t, "/", localPath("/\\evil.com/"))
+	assert.Equal(t, "/", localPath("search"))
+}
+
+func TestAuth(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	assert.Nil(t, err)
+	db := &userStorage{
+		users:    []User{{ID: 1, Username: "admin", PasswordHash: hash, Admin: true}},
+		sessions: make(map[string]int64),
+	}
+	holder := config.NewHolder(config.Config{
+		Server: config.ServerConfig{PublicUrl: "https://news.example.com"},
+		Auth:   config.AuthConfig{RequireLogin: true, SessionTTL: time.Hour},
+	})
+
+	r := gin.Default()
+	r.SetHTMLTemplate(template.Must(template.ParseFS(frontendFs, "templates/*.html")))
+	r.Use(authenticate(db, holder))
+	r.GET("/login", handleLoginPage())
+	r.POST("/login", handleLogin(db, holder))
+	r.POST("/logout", handleLogout(db, holder))
+	reader := r.Group("/", requireReader(holder))
+	reader.GET("/about", handleAboutPage())
+	reader.GET("/api/v1/tags", handleGetTags(holder))
+
+	do := func(method string, target string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	sessionCookieOf := func(w *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookie {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("anonymous", func(t *testing.T) {
+		w := do(http.MethodGet, "/about", nil, nil)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/login?next=%2Fabout", w.Header().Get("Location"))
+
+		w = do(http.MethodGet, "/api/v1/tags", nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = do(http.MethodGet, "/login?next=%2Fabout", nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `name="next" value="/about"`)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		w := do(http.MethodPost, "/login", url.Values{"username": {"admin"}, "password": {"wrong horse"}}, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "Wrong username or password")
+		assert.Nil(t, sessionCookieOf(w))
+
+		w = do(http.MethodPost, "/login", url.Values{"username": {"nobody"}, "password": {"correct horse"}}, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("login and logout", func(t *testing.T) {
+		w := do(http.MethodPost, "/login", url.Values{"username": {"Admin"}, "password": {"correct horse"}, "next": {"/about"}}, nil)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/about", w.Header().Get("Location"))
+
+		cookie := sessionCookieOf(w)
+		if !assert.NotNil(t, cookie) {
+			return
+		}
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		assert.Equal(t, 3600, cookie.MaxAge)
+		assert.Len(t, db.sessions, 1)
+		assert.NotContains(t, db.sessions, cookie.Value)
+
+		w = do(http.MethodGet, "/about", nil, cookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `action="/logout"`)
+
+		w = do(http.MethodPost, "/logout", nil, cookie)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Empty(t, db.sessions)
+		if removed := sessionCookieOf(w); assert.NotNil(t, removed) {
+			assert.Less(t, removed.MaxAge, 0)
+		}
+
+		// the old cookie doesn't work anymore and is removed
+		w = do(http.MethodGet, "/about", nil, cookie)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.NotNil(t, sessionCookieOf(w))
+	})
+
+	t.Run("anonymous access allowed", func(t *testing.T) {
+		appConfig := holder.Get()
+		appConfig.Auth.RequireLogin = false
+		holder.Set(appConfig)
+
+		w := do(http.MethodGet, "/about", nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `href="/login"`)
+	})
+}
+
+func TestRequireAdmin(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	assert.Nil(t, err)
+	db := &userStorage{
+		users: []User{
+			{ID: 1, Username: "admin", PasswordHash: hash, Admin: true},
+			{ID: 2, Username: "reader", PasswordHash: hash},
+		},
+		sessions: make(map[string]int64),
+	}
+	holder := config.NewHolder(config.Config{Auth: config.AuthConfig{SessionTTL: time.Hour}})
+
+	r := gin.Default()
+	r.Use(authenticate(db, holder))
+	r.POST("/login", handleLogin(db, holder))
+	sources := r.Group("/api/v1/sources")
+	sources.GET("", func(c *gin.Context) { c.Status(http.StatusOK) })
+	sources.POST("/:name/refresh", requireAdmin(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method string, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	login := func(username string) *http.Cookie {
+		w := httptest.NewRecorder()
+		form := url.Values{"username": {username}, "password": {"correct horse"}}
+		req, _ := http.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		r.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookie {
+				assert.True(t, c.Secure)
+				return c
+			}
+		}
+		t.Fatalf("%s wasn't logged in", username)
+		return nil
+	}
+
+	w := do(http.MethodGet, "/api/v1/sources", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(http.MethodPost, "/api/v1/sources/bbc/refresh", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error": "login required"}`, w.Body.String())
+
+	w = do(http.MethodPost, "/api/v1/sources/bbc/refresh", login("reader"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error": "admin required"}`, w.Body.String())
+
+	w = do(http.MethodPost, "/api/v1/sources/bbc/refresh", login("admin"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// feedMaxAge is how long clients and proxies may cache generated feeds
+	feedMaxAge = 5 * time.Minute
+	// maxFeedItems limits the number of items in a generated feed
+	maxFeedItems  = 200
+	feedGenerator = "allnews"
+)
+
+// FeedConfig provides sources for generated feeds
+type FeedConfig interface {
+	ConfigGetter
+	TaggedResourcesGetter
+}
+
+// feedData is a format-independent feed
+type feedData struct {
+	Title       string
+	Description string
+	HomeUrl     string
+	SelfUrl     string
+	Updated     time.Time
+	Items       []feedItem
+}
+
+type feedItem struct {
+	feed.Article
+	// SourceUrl is the url of the feed the article was collected from, empty if the source is gone
+	SourceUrl string
+}
+
+func (i feedItem) updated() time.Time {
+	if i.UpdatedAt != nil {
+		return *i.UpdatedAt
+	}
+	return i.Published
+}
+
+// feedFormat renders feedData into one of the feed formats
+type feedFormat struct {
+	contentType string
+	render      func(feedData) ([]byte, error)
+}
+
+var (
+	rssFormat  = feedFormat{contentType: "application/rss+xml; charset=utf-8", render: renderRSS}
+	atomFormat = feedFormat{contentType: "application/atom+xml; charset=utf-8", render: renderAtom}
+	jsonFormat = feedFormat{contentType: "application/feed+json; charset=utf-8", render: renderJSONFeed}
+)
+
+// baseUrl returns the configured public url of the site or guesses it from the request
+func baseUrl(c *gin.Context, publicUrl string) string {
+	if publicUrl != "" {
+		return strings.TrimSuffix(publicUrl, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// feedCacheControl lets shared caches keep the feed only when its links come from public_url.
+// Links guessed from the Host header or feeds behind login are cached by the client alone.
+func feedCacheControl(c *gin.Context, appConfig config.Config) string {
+	maxAge := int(feedMaxAge.Seconds())
+	if appConfig.Server.PublicUrl == "" {
+		c.Header("Vary", "Host")
+		return fmt.Sprintf("private, max-age=%d", maxAge)
+	}
+	if appConfig.Auth.RequireLogin {
+		return fmt.Sprintf("private, max-age=%d", maxAge)
+	}
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
+
+// feedTitle describes filters of the feed
+func feedTitle(params ArticleSearchParams) string {
+	filters := make([]string, 0, len(params.Tags)+len(params.Resources)+1)
+	filters = append(filters, params.Tags...)
+	filters = append(filters, params.Resources...)
+	if params.Filter != "" {
+		filters = append(filters, fmt.Sprintf("%q", params.Filter))
+	}
+
+	if len(filters) == 0 {
+		return "Allnews"
+	}
+	return "Allnews: " + strings.Join(filters, ", ")
+}
+
+func handleFeed(db ArticleGetter, cfg FeedConfig, format feedFormat) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params ArticleSearchParams
+		if err := c.ShouldBind(&params); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if params.Limit == 0 || params.Limit > maxFeedItems {
+			params.Limit = maxFeedItems
+		}
+
+		options, found, err := searchOptions(params, cfg)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		articles := []feed.Article{}
+		if found {
+			articles, err = db.GetArticles(c.Request.Context(), options...)
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		appConfig := cfg.Get()
+		sourceUrls := make(map[string]string, len(appConfig.Sources))
+		for _, s := range appConfig.Sources {
+			sourceUrls[s.Name] = s.FeedUrl
+		}
+
+		base := baseUrl(c, appConfig.Server.PublicUrl)
+		data := feedData{
+			Title:       feedTitle(params),
+			Description: "News collected by allnews",
+			HomeUrl:     base + "/",
+			SelfUrl:     base + c.Request.URL.RequestURI(),
+			Items:       make([]feedItem, 0, len(articles)),
+		}
+		for _, a := range articles {
+			item := feedItem{Article: a, SourceUrl: sourceUrls[a.Resource]}
+			if item.updated().After(data.Updated) {
+				data.Updated = item.updated()
+			}
+			data.Items = append(data.Items, item)
+		}
+
+		body, err := format.render(data)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// ServeContent answers conditional requests using ETag and Last-Modified
+		c.Header("Content-Type", format.contentType)
+		c.Header("Cache-Control", feedCacheControl(c, appConfig))
+		c.Header("ETag", fmt.Sprintf(`W/"%x"`, sha256.Sum256(body)))
+		http.ServeContent(c.Writer, c.Request, "", data.Updated, bytes.NewReader(body))
+	}
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DcNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description,omitempty"`
+	GUID        rssGUID    `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Creator     string     `xml:"dc:creator"`
+	Source      *rssSource `xml:"source,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssSource struct {
+	Url  string `xml:"url,attr"`
+	Name string `xml:",chardata"`
+}
+
+func renderRSS(data feedData) ([]byte, error) {
+	channel := rssChannel{
+		Title:       data.Title,
+		Link:        data.HomeUrl,
+		Description: data.Description,
+		SelfLink:    atomLink{Href: data.SelfUrl, Rel: "self", Type: "application/rss+xml"},
+		Generator:   feedGenerator,
+		Items:       make([]rssItem, 0, len(data.Items)),
+	}
+	if !data.Updated.IsZero() {
+		channel.LastBuildDate = data.Updated.Format(time.RFC1123Z)
+	}
+
+	for _, item := range data.Items {
+		rss := rssItem{
+			Title:       item.Title,
+			Link:        item.Url,
+			Description: item.Description,
+			GUID:        rssGUID{IsPermaLink: true, Value: item.Url},
+			PubDate:     item.Published.Format(time.RFC1123Z),
+			Creator:     item.Resource,
+		}
+		if item.SourceUrl != "" {
+			rss.Source = &rssSource{Url: item.SourceUrl, Name: item.Resource}
+		}
+		channel.Items = append(channel.Items, rss)
+	}
+
+	return marshalXML(rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DcNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: channel,
+	})
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string      `xml:"title"`
+	Subtitle  string      `xml:"subtitle"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Summary   *atomText   `xml:"summary,omitempty"`
+	Author    atomPerson  `xml:"author"`
+	Source    *atomSource `xml:"source,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomSource struct {
+	Title string   `xml:"title"`
+	Link  atomLink `xml:"link"`
+}
+
+func renderAtom(data feedData) ([]byte, error) {
+	updated := data.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	atom := atomFeed{
+		Title:    data.Title,
+		Subtitle: data.Description,
+		ID:       data.SelfUrl,
+		Updated:  updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: data.SelfUrl, Rel: "self", Type: "application/atom+xml"},
+			{Href: data.HomeUrl, Rel: "alternate", Type: "text/html"},
+		},
+		Generator: feedGenerator,
+		Entries:   make([]atomEntry, 0, len(data.Items)),
+	}
+
+	for _, item := range data.Items {
+		entry := atomEntry{
+			Title:     item.Title,
+			ID:        item.Url,
+			Link:      atomLink{Href: item.Url, Rel: "alternate"},
+			Published: item.Published.Format(time.RFC3339),
+			Updated:   item.updated().Format(time.RFC3339),
+			Author:    atomPerson{Name: item.Resource},
+		}
+		if item.Description != "" {
+			entry.Summary = &atomText{Type: "html", Value: item.Description}
+		}
+		if item.SourceUrl != "" {
+			entry.Source = &atomSource{Title: item.Resource, Link: atomLink{Href: item.SourceUrl, Rel: "self"}}
+		}
+		atom.Entries = append(atom.Entries, entry)
+	}
+
+	return marshalXML(atom)
+}
+
+func marshalXML(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return body, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// jsonFeed is JSON Feed version 1.1, see https://www.jsonfeed.org/version/1.1/
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageUrl string         `json:"home_page_url"`
+	FeedUrl     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	Url           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished time.Time        `json:"date_published"`
+	DateModified  *time.Time       `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	Url  string `json:"url,omitempty"`
+}
+
+func renderJSONFeed(data feedData) ([]byte, error) {
+	result := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       data.Title,
+		HomePageUrl: data.HomeUrl,
+		FeedUrl:     data.SelfUrl,
+		Description: data.Description,
+		Items:       make([]jsonFeedItem, 0, len(data.Items)),
+	}
+
+	for _, item := range data.Items {
+		result.Items = append(result.Items, jsonFeedItem{
+			ID:            item.Url,
+			Url:           item.Url,
+			Title:         item.Title,
+			ContentHTML:   item.Description,
+			DatePublished: item.Published,
+			DateModified:  item.UpdatedAt,
+			Authors:       []jsonFeedAuthor{{Name: item.Resource, Url: item.SourceUrl}},
+		})
+	}
+
+	return json.MarshalIndent(result, "", "  ")
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/mmcdole/gofeed"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
+)
+
+// filteringStorage applies limit, offset and resource names the way the real storage does
+type filteringStorage struct {
+	articles []feed.Article
+}
+
+func (s *filteringStorage) GetArticles(ctx context.Context, options ...GetArticleOption) ([]feed.Article, error) {
+	var params ArticleSearchParams
+	for _, option := range options {
+		option(&params)
+	}
+
+	result := make([]feed.Article, 0)
+	skipped := uint64(0)
+	for _, a := range s.articles {
+		if len(params.Resources) > 0 && !slices.Contains(params.Resources, a.Resource) {
+			continue
+		}
+		if skipped < params.Offset {
+			skipped++
+			continue
+		}
+		if params.Limit > 0 && uint64(len(result)) == params.Limit {
+			break
+		}
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+func (s *filteringStorage) CountArticles(ctx context.Context, options ...GetArticleOption) (int, error) {
+	options = append(options, WithLimit(0), WithOffset(0))
+	articles, err := s.GetArticles(ctx, options...)
+	return len(articles), err
+}
+
+func TestFeeds(t *testing.T) {
+	published := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	db := &filteringStorage{articles: []feed.Article{
+		{ID: 2, Resource: "uk", Url: "https://uk.com/2", Title: "Election <results>", Description: "<p>Votes</p>", Published: published},
+		{ID: 1, Resource: "us", Url: "https://us.com/1", Title: "Markets", Published: published.Add(-time.Hour)},
+	}}
+	holder := config.NewHolder(config.Config{
+		Server: config.ServerConfig{PublicUrl: "https://news.example.com/"},
+		Sources: []config.SourceConfig{
+			{Name: "uk", FeedUrl: "https://uk.com/rss", Tags: map[string][]string{"country": {"UK"}}},
+			{Name: "us", FeedUrl: "https://us.com/rss", Tags: map[string][]string{"country": {"US"}}},
+		},
+	})
+
+	r := gin.Default()
+	r.GET("/feeds/rss.xml", handleFeed(db, holder, rssFormat))
+	r.GET("/feeds/atom.xml", handleFeed(db, holder, atomFormat))
+	r.GET("/feeds/feed.json", handleFeed(db, holder, jsonFormat))
+
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/feeds/rss.xml", "/feeds/atom.xml", "/feeds/feed.json"} {
+		t.Run(path, func(t *testing.T) {
+			w := get(path, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+			assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", w.Header().Get("Last-Modified"))
+
+			parsed, err := gofeed.NewParser().ParseString(w.Body.String())
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, "Allnews", parsed.Title)
+			if assert.Len(t, parsed.Items, 2) {
+				item := parsed.Items[0]
+				assert.Equal(t, "Election <results>", item.Title)
+				assert.Equal(t, "https://uk.com/2", item.Link)
+				assert.True(t, published.Equal(*item.PublishedParsed))
+				if assert.NotEmpty(t, item.Authors) {
+					assert.Equal(t, "uk", item.Authors[0].Name)
+				}
+			}
+
+			w = get(path, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+			assert.Equal(t, http.StatusNotModified, w.Code)
+		})
+	}
+
+	t.Run("filtered", func(t *testing.T) {
+		w := get("/feeds/atom.xml?tags[]=country:UK&limit=10", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<title>Allnews: country:UK</title>`)
+		assert.Contains(t, w.Body.String(), `href="https://news.example.com/feeds/atom.xml?tags[]=country:UK&amp;limit=10" rel="self"`)
+		assert.Contains(t, w.Body.String(), `<link href="https://uk.com/rss" rel="self"></link>`)
+		assert.NotContains(t, w.Body.String(), "Markets")
+
+		w = get("/feeds/rss.xml?resources[]=us", nil)
+		assert.Contains(t, w.Body.String(), `<source url="https://us.com/rss">us</source>`)
+		assert.NotContains(t, w.Body.String(), "Election")
+	})
+
+	t.Run("nothing matches", func(t *testing.T) {
+		w := get("/feeds/feed.json?tags[]=country:FR", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"items": []`)
+
+		w = get("/feeds/feed.json?tags[]=country", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("no public url", func(t *testing.T) {
+		appConfig := holder.Get()
+		appConfig.Server.PublicUrl = ""
+		holder.Set(appConfig)
+		defer func() {
+			appConfig.Server.PublicUrl = "https://news.example.com/"
+			holder.Set(appConfig)
+		}()
+
+		w := get("/feeds/atom.xml", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "private, max-age=300", w.Header().Get("Cache-Control"))
+		assert.Equal(t, "Host", w.Header().Get("Vary"))
+	})
+
+	t.Run("login required", func(t *testing.T) {
+		appConfig := holder.Get()
+		appConfig.Auth.RequireLogin = true
+		holder.Set(appConfig)
+		defer func() {
+			appConfig.Auth.RequireLogin = false
+			holder.Set(appConfig)
+		}()
+
+		w := get("/feeds/atom.xml", nil)
+		assert.Equal(t, "private, max-age=300", w.Header().Get("Cache-Control"))
+	})
+}
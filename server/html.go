@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
@@ -32,42 +33,24 @@ func init() {
 	}
 }
 
-func handleIndexPage() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"Url":   c.Request.URL.Path,
-			"Title": "Main page",
-		})
-	}
-}
-
 func handleAboutPage() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.HTML(http.StatusOK, "about.html", gin.H{
+		renderPage(c, http.StatusOK, "about.html", gin.H{
 			"Url":   c.Request.URL.Path,
 			"Title": "About",
 		})
 	}
 }
 
-func handleSearchPage() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.HTML(http.StatusOK, "search.html", gin.H{
-			"Url":   c.Request.URL.Path,
-			"Title": "Search",
-		})
-	}
-}
-
 type StatsGetter interface {
 	GetArticleStats(context.Context) ([]feed.ArticleStats, error)
 }
 
-func handleStatsPage(db StatsGetter, tags map[string][]string) gin.HandlerFunc {
+func handleStatsPage(db StatsGetter, tags TagsGetter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		stats, err := db.GetArticleStats(c.Request.Context())
 		if err != nil {
-			c.HTML(http.StatusInternalServerError, "error.html", gin.H{
+			renderPage(c, http.StatusInternalServerError, "error.html", gin.H{
 				"Url":   c.Request.URL.Path,
 				"Title": "Stats",
 				"Error": fmt.Sprintf("Error happened: %v", err),
@@ -75,11 +58,36 @@ func handleStatsPage(db StatsGetter, tags map[string][]string) gin.HandlerFunc {
 			return
 		}
 
-		c.HTML(http.StatusOK, "stats.html", gin.H{
+		renderPage(c, http.StatusOK, "stats.html", gin.H{
 			"Url":       c.Request.URL.Path,
 			"Title":     "Stats",
 			"Resources": stats,
-			"Tags":      tags,
+			"Tags":      tags.GetAllTags(),
+		})
+	}
+}
+
+func handleHistoryPage(db HistoryGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		article, revisions, err := db.GetArticleHistory(c.Request.Context(), c.Query("url"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			renderPage(c, status, "error.html", gin.H{
+				"Url":   c.Request.URL.Path,
+				"Title": "History",
+				"Error": fmt.Sprintf("Error happened: %v", err),
+			})
+			return
+		}
+
+		renderPage(c, http.StatusOK, "history.html", gin.H{
+			"Url":       c.Request.URL.Path,
+			"Title":     "History",
+			"Article":   article,
+			"Revisions": revisions,
 		})
 	}
 }
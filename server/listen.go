@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdListenFdsStart is the first file descriptor passed by systemd socket activation
+const systemdListenFdsStart = 3
+
+// listen creates listener for listen_addr, which is one of:
+//   - host:port
+//   - unix:/path/to/socket
+//   - systemd, to use the first socket passed by systemd socket activation
+func listen(addr string) (net.Listener, error) {
+	if addr == "systemd" {
+		return systemdListener()
+	}
+
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// socket left by a previous run that wasn't stopped cleanly
+		if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("listen_addr is systemd, but no sockets were passed by systemd")
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, fmt.Errorf("listen_addr is systemd, but LISTEN_FDS is %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	// so that child processes don't think sockets are meant for them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFdsStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		l, err := listen("127.0.0.1:0")
+		assert.Nil(t, err)
+		assert.Equal(t, "tcp", l.Addr().Network())
+		l.Close()
+	})
+
+	t.Run("unix socket left from previous run", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "allnews.sock")
+
+		stale, err := net.Listen("unix", path)
+		assert.Nil(t, err)
+		// keep the file around like a crashed process would
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		l, err := listen("unix:" + path)
+		assert.Nil(t, err)
+		assert.Equal(t, "unix", l.Addr().Network())
+		l.Close()
+	})
+
+	t.Run("systemd without sockets", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", "1")
+		_, err := listen("systemd")
+		assert.NotNil(t, err)
+	})
+}
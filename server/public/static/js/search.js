@@ -0,0 +1,166 @@
+// Search page. Without javascript the form is submitted and results are rendered by the server,
+// with it results come from /api/v1/articles and the url is kept in sync with the form.
+const searchPageSize = 20;
+const searchPagesAround = 2;
+
+function escapeHtml(text) {
+  const div = document.createElement('div');
+  div.textContent = text;
+  return div.innerHTML;
+}
+
+function escapeRegExp(text) {
+  return text.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+}
+
+// safeUrl keeps only http and https links, so that a javascript: url stored before
+// it was filtered out can't run on the page
+function safeUrl(url) {
+  try {
+    const parsed = new URL(url);
+    return parsed.protocol === 'http:' || parsed.protocol === 'https:' ? parsed.href : '#';
+  } catch (e) {
+    return '#';
+  }
+}
+
+document.addEventListener('alpine:init', () => {
+  Alpine.data('search', () => ({
+    filter: '',
+    from: '',
+    to: '',
+    tags: [],
+    resources: [],
+    page: 1,
+    // term is the filter results were found with, it's highlighted until the next search
+    term: '',
+    articles: [],
+    total: 0,
+    loaded: false,
+    loading: false,
+    error: '',
+
+    init() {
+      this.readUrl();
+      window.addEventListener('popstate', () => {
+        this.readUrl();
+        this.search();
+      });
+    },
+
+    get offset() {
+      return (this.page - 1) * searchPageSize;
+    },
+
+    readUrl() {
+      const params = new URLSearchParams(window.location.search);
+      this.filter = params.get('filter') || '';
+      this.from = params.get('from') || '';
+      this.to = params.get('to') || '';
+      this.tags = params.getAll('tags[]');
+      this.resources = params.getAll('resources[]');
+      this.page = Math.max(parseInt(params.get('page'), 10) || 1, 1);
+    },
+
+    // formParams are parameters of the page url, the same the server renders results for
+    formParams() {
+      const params = new URLSearchParams();
+      if (this.filter) params.set('filter', this.filter);
+      if (this.from) params.set('from', this.from);
+      if (this.to) params.set('to', this.to);
+      this.tags.forEach((tag) => params.append('tags[]', tag));
+      this.resources.forEach((resource) => params.append('resources[]', resource));
+      if (this.page > 1) params.set('page', this.page);
+      return params;
+    },
+
+    apiParams() {
+      const params = new URLSearchParams();
+      if (this.filter.trim()) params.set('filter', this.filter.trim());
+      if (this.from) params.set('date_start', this.from + 'T00:00:00Z');
+      if (this.to) params.set('date_end', this.to + 'T23:59:59Z');
+      this.tags.forEach((tag) => params.append('tags[]', tag));
+      this.resources.forEach((resource) => params.append('resources[]', resource));
+      params.set('limit', searchPageSize);
+      params.set('offset', this.offset);
+      params.set('total', '1');
+      return params;
+    },
+
+    pushUrl() {
+      const query = this.formParams().toString();
+      history.pushState(null, '', '/search' + (query ? '?' + query : ''));
+    },
+
+    submit() {
+      this.page = 1;
+      this.pushUrl();
+      this.search();
+    },
+
+    go(page) {
+      this.page = page;
+      this.pushUrl();
+      this.search();
+      window.scrollTo(0, 0);
+    },
+
+    empty() {
+      return !this.filter.trim() && !this.from && !this.to && !this.tags.length && !this.resources.length;
+    },
+
+    async search() {
+      // like the server, an empty form shows no results rather than everything
+      if (this.empty()) {
+        this.articles = [];
+        this.total = null;
+        this.loaded = true;
+        return;
+      }
+
+      this.loading = true;
+      this.error = '';
+      try {
+        const response = await fetch('/api/v1/articles?' + this.apiParams().toString());
+        const body = await response.json();
+        if (!response.ok && response.status !== 404) {
+          this.error = body.error || response.statusText;
+          return;
+        }
+        this.articles = body.articles;
+        this.total = body.total;
+        this.term = this.filter.trim();
+        this.loaded = true;
+      } catch (e) {
+        this.error = e.message;
+      } finally {
+        this.loading = false;
+      }
+    },
+
+    lastPage() {
+      return Math.ceil(this.total / searchPageSize);
+    },
+
+    pages() {
+      const pages = [];
+      const last = Math.min(this.lastPage(), this.page + searchPagesAround);
+      for (let n = Math.max(1, this.page - searchPagesAround); n <= last; n++) {
+        pages.push(n);
+      }
+      return pages;
+    },
+
+    // highlight escapes text and marks the term in it, matches are at odd positions after split
+    highlight(text) {
+      if (!this.term) {
+        return escapeHtml(text || '');
+      }
+      const term = new RegExp('(' + escapeRegExp(this.term) + ')', 'gi');
+      return (text || '')
+        .split(term)
+        .map((part, i) => (i % 2 ? '<mark>' + escapeHtml(part) + '</mark>' : escapeHtml(part)))
+        .join('');
+    },
+  }));
+});
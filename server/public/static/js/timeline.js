@@ -0,0 +1,110 @@
+// Timeline of the index page. Filters are kept in the url, so filtered views can be shared,
+// and the timeline is re-rendered by the server with partial=1. Articles saved since the page
+// was loaded are counted from /api/v1/stream and shown on request.
+document.addEventListener('alpine:init', () => {
+  Alpine.data('timeline', () => ({
+    tags: [],
+    resources: [],
+    filter: '',
+    loading: false,
+    newCount: 0,
+    stream: null,
+
+    init() {
+      this.readUrl();
+      this.listen();
+      window.addEventListener('popstate', () => {
+        this.readUrl();
+        this.reload(false);
+      });
+    },
+
+    // listen counts new articles matching current filters, the browser reconnects by itself
+    listen() {
+      if (this.stream) {
+        this.stream.close();
+      }
+      this.newCount = 0;
+      this.stream = new EventSource('/api/v1/stream' + this.suffix());
+      this.stream.addEventListener('article', () => {
+        this.newCount++;
+      });
+    },
+
+    readUrl() {
+      const params = new URLSearchParams(window.location.search);
+      this.tags = params.getAll('tags[]');
+      this.resources = params.getAll('resources[]');
+      this.filter = params.get('filter') || '';
+    },
+
+    params() {
+      const params = new URLSearchParams();
+      this.tags.forEach((tag) => params.append('tags[]', tag));
+      this.resources.forEach((resource) => params.append('resources[]', resource));
+      if (this.filter) {
+        params.set('filter', this.filter);
+      }
+      return params;
+    },
+
+    // suffix is the query string of current filters for links
+    suffix() {
+      const query = this.params().toString();
+      return query ? '?' + query : '';
+    },
+
+    hasTag(tag) {
+      return this.tags.includes(tag);
+    },
+
+    toggleTag(tag) {
+      this.tags = this.hasTag(tag) ? this.tags.filter((t) => t !== tag) : [...this.tags, tag];
+      this.reload(true);
+    },
+
+    async fetchPartial(params) {
+      params.set('partial', '1');
+      this.loading = true;
+      try {
+        const response = await fetch('/?' + params.toString());
+        const fragment = document.createElement('template');
+        fragment.innerHTML = await response.text();
+        return fragment.content;
+      } finally {
+        this.loading = false;
+      }
+    },
+
+    async reload(push) {
+      if (push) {
+        history.pushState(null, '', '/' + this.suffix());
+      }
+      const content = await this.fetchPartial(this.params());
+      this.$refs.timeline.replaceChildren(content);
+      this.listen();
+    },
+
+    // loadMore appends the next page, continuing the last day if the page starts with it
+    async loadMore(link) {
+      const content = await this.fetchPartial(new URL(link.href).searchParams);
+      link.closest('.timeline-more').remove();
+
+      const timeline = this.$refs.timeline;
+      content.querySelectorAll('.timeline-day').forEach((day) => {
+        const days = timeline.querySelectorAll('.timeline-day');
+        const last = days[days.length - 1];
+        if (last && last.dataset.day === day.dataset.day) {
+          last.querySelector('.timeline-articles').append(...day.querySelectorAll('.timeline-article'));
+        } else {
+          timeline.append(day);
+        }
+      });
+
+      const more = content.querySelector('.timeline-more');
+      if (more) {
+        timeline.append(more);
+      }
+    },
+  }));
+});
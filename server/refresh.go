@@ -0,0 +1,96 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+)
+
+// refreshLimiter allows one on-demand refresh of a source per interval,
+// so that the api can't be used to hammer publishers
+type refreshLimiter struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newRefreshLimiter(interval time.Duration) *refreshLimiter {
+	return &refreshLimiter{interval: interval, now: time.Now, last: make(map[string]time.Time)}
+}
+
+// allow reports whether the source can be refreshed now, and if not, how long to wait
+func (l *refreshLimiter) allow(name string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if last, ok := l.last[name]; ok {
+		if wait := last.Add(l.interval).Sub(now); wait > 0 {
+			return false, wait
+		}
+	}
+	l.last[name] = now
+	return true, 0
+}
+
+type fetchResultJSON struct {
+	Source     string `json:"source"`
+	Status     string `json:"status"`
+	ItemsSeen  int    `json:"items_seen"`
+	Inserted   int    `json:"inserted"`
+	Updated    int    `json:"updated"`
+	Duplicates int    `json:"duplicates"`
+	Rejected   int    `json:"rejected"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newFetchResultJSON(r feed.FetchResult) fetchResultJSON {
+	result := fetchResultJSON{
+		Source:     r.Source,
+		Status:     "ok",
+		ItemsSeen:  r.ItemsSeen,
+		Inserted:   r.Saved.Inserted,
+		Updated:    r.Saved.Updated,
+		Duplicates: r.Saved.Duplicates,
+		Rejected:   len(r.Saved.Rejected),
+	}
+	if r.Err != nil {
+		result.Status = "error"
+		result.Error = r.Err.Error()
+	}
+	return result
+}
+
+type SourceFetcher func(*gin.Context, feed.Source) feed.FetchResult
+
+// handleRefreshSource fetches the source right away. Failure to get the feed is reported
+// with 502 status and the same body as a successful fetch.
+func handleRefreshSource(db SourceManager, fetch SourceFetcher, limiter *refreshLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		source, err := db.GetSource(c.Request.Context(), c.Param("name"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if ok, wait := limiter.allow(source.Name); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "source was refreshed recently, try again in " + wait.Round(time.Second).String()})
+			return
+		}
+
+		result := fetch(c, source)
+		status := http.StatusOK
+		if result.Err != nil {
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{"result": newFetchResultJSON(result)})
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshSource(t *testing.T) {
+	db := &testSourceStorage{sources: map[string]feed.Source{
+		"good": {SourceConfig: config.SourceConfig{Name: "good"}},
+		"bad":  {SourceConfig: config.SourceConfig{Name: "bad"}},
+	}}
+
+	fetch := func(c *gin.Context, source feed.Source) feed.FetchResult {
+		result := feed.FetchResult{Source: source.Name, ItemsSeen: 3, Saved: feed.SaveResult{Inserted: 2, Duplicates: 1}}
+		if source.Name == "bad" {
+			result = feed.FetchResult{Source: source.Name, Err: errors.New("connection refused")}
+		}
+		return result
+	}
+
+	now := time.Now()
+	limiter := newRefreshLimiter(time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	r := gin.Default()
+	r.POST("/sources/:name/refresh", handleRefreshSource(db, fetch, limiter))
+
+	refresh := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/sources/"+name+"/refresh", nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := refresh("good")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"ok"`)
+	assert.Contains(t, w.Body.String(), `"items_seen":3`)
+	assert.Contains(t, w.Body.String(), `"inserted":2`)
+
+	w = refresh("good")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	now = now.Add(time.Minute)
+	w = refresh("good")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = refresh("bad")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "connection refused")
+
+	w = refresh("missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	searchPageSize = 20
+	// searchPagesAround is how many page links are shown on each side of the current page
+	searchPagesAround = 2
+)
+
+// SearchConfig provides sources and tags for the search form
+type SearchConfig interface {
+	ConfigGetter
+	TagsGetter
+	TaggedResourcesGetter
+}
+
+// searchPageParams are parameters of the search form, dates are days like 2006-01-02
+type searchPageParams struct {
+	Filter    string   `form:"filter"`
+	From      string   `form:"from"`
+	To        string   `form:"to"`
+	Tags      []string `form:"tags[]"`
+	Resources []string `form:"resources[]"`
+	Page      int      `form:"page"`
+}
+
+func (p searchPageParams) empty() bool {
+	return strings.TrimSpace(p.Filter) == "" && p.From == "" && p.To == "" && len(p.Tags) == 0 && len(p.Resources) == 0
+}
+
+func (p searchPageParams) page() int {
+	if p.Page < 1 {
+		return 1
+	}
+	return p.Page
+}
+
+// articleParams converts form parameters into api ones, whole days are searched in UTC
+func (p searchPageParams) articleParams() (ArticleSearchParams, error) {
+	params := ArticleSearchParams{
+		Filter:    strings.TrimSpace(p.Filter),
+		Tags:      p.Tags,
+		Resources: p.Resources,
+		Limit:     searchPageSize,
+		Offset:    uint64(p.page()-1) * searchPageSize,
+	}
+
+	if p.From != "" {
+		from, err := time.Parse(time.DateOnly, p.From)
+		if err != nil {
+			return params, fmt.Errorf("from has to be a date like 2006-01-02, got %q", p.From)
+		}
+		params.DateStart = from
+	}
+	if p.To != "" {
+		to, err := time.Parse(time.DateOnly, p.To)
+		if err != nil {
+			return params, fmt.Errorf("to has to be a date like 2006-01-02, got %q", p.To)
+		}
+		params.DateEnd = to.Add(24*time.Hour - time.Second)
+	}
+	return params, nil
+}
+
+// query returns the form parameters for the given page
+func (p searchPageParams) query(page int) url.Values {
+	query := url.Values{}
+	if p.Filter != "" {
+		query.Set("filter", p.Filter)
+	}
+	if p.From != "" {
+		query.Set("from", p.From)
+	}
+	if p.To != "" {
+		query.Set("to", p.To)
+	}
+	for _, tag := range p.Tags {
+		query.Add("tags[]", tag)
+	}
+	for _, resource := range p.Resources {
+		query.Add("resources[]", resource)
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	return query
+}
+
+// highlight escapes text and marks case-insensitive occurrences of term in it
+func highlight(text string, term string) template.HTML {
+	if term == "" {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(term))
+	var b strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:match[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[match[0]:match[1]]))
+		b.WriteString("</mark>")
+		last = match[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+type searchResult struct {
+	feed.Article
+	Ago             string
+	TitleHTML       template.HTML
+	DescriptionHTML template.HTML
+}
+
+type searchOption struct {
+	Value    string
+	Selected bool
+}
+
+type searchTagGroup struct {
+	Category string
+	Options  []searchOption
+}
+
+type searchPageLink struct {
+	Number  int
+	Href    string
+	Current bool
+}
+
+// pagination returns links to pages around the current one, and to the previous and next pages
+func pagination(p searchPageParams, total int) (pages []searchPageLink, prev string, next string) {
+	current := p.page()
+	last := (total + searchPageSize - 1) / searchPageSize
+
+	pages = make([]searchPageLink, 0)
+	for n := max(1, current-searchPagesAround); n <= min(last, current+searchPagesAround); n++ {
+		pages = append(pages, searchPageLink{Number: n, Href: "/search?" + p.query(n).Encode(), Current: n == current})
+	}
+	if current > 1 {
+		prev = "/search?" + p.query(current-1).Encode()
+	}
+	if current < last {
+		next = "/search?" + p.query(current+1).Encode()
+	}
+	return pages, prev, next
+}
+
+func searchFormOptions(cfg SearchConfig, p searchPageParams) ([]searchOption, []searchTagGroup) {
+	sources := make([]searchOption, 0)
+	for _, s := range cfg.Get().Sources {
+		sources = append(sources, searchOption{Value: s.Name, Selected: slices.Contains(p.Resources, s.Name)})
+	}
+	slices.SortFunc(sources, func(a, b searchOption) bool { return a.Value < b.Value })
+
+	allTags := cfg.GetAllTags()
+	categories := maps.Keys(allTags)
+	slices.Sort(categories)
+	tags := make([]searchTagGroup, 0, len(categories))
+	for _, category := range categories {
+		values := slices.Clone(allTags[category])
+		slices.Sort(values)
+		group := searchTagGroup{Category: category}
+		for _, value := range values {
+			tag := category + ":" + value
+			group.Options = append(group.Options, searchOption{Value: tag, Selected: slices.Contains(p.Tags, tag)})
+		}
+		tags = append(tags, group)
+	}
+	return sources, tags
+}
+
+func handleSearchPage(db ArticleSearcher, cfg SearchConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data := gin.H{
+			"Url":   c.Request.URL.Path,
+			"Title": "Search",
+		}
+		showError := func(status int, err error) {
+			data["Error"] = err.Error()
+			renderPage(c, status, "search.html", data)
+		}
+
+		var form searchPageParams
+		err := c.ShouldBind(&form)
+		data["Form"] = form
+		data["Sources"], data["TagGroups"] = searchFormOptions(cfg, form)
+		if err != nil {
+			showError(http.StatusBadRequest, err)
+			return
+		}
+		if form.empty() {
+			renderPage(c, http.StatusOK, "search.html", data)
+			return
+		}
+
+		params, err := form.articleParams()
+		if err != nil {
+			showError(http.StatusBadRequest, err)
+			return
+		}
+		options, found, err := searchOptions(params, cfg)
+		if err != nil {
+			showError(http.StatusBadRequest, err)
+			return
+		}
+
+		articles := []feed.Article{}
+		total := 0
+		if found {
+			ctx := c.Request.Context()
+			if articles, err = db.GetArticles(ctx, options...); err == nil {
+				total, err = db.CountArticles(ctx, options...)
+			}
+			if err != nil {
+				showError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		now := time.Now()
+		results := make([]searchResult, 0, len(articles))
+		for _, a := range articles {
+			results = append(results, searchResult{
+				Article:         a,
+				Ago:             relativeTime(a.Published, now),
+				TitleHTML:       highlight(a.Title, params.Filter),
+				DescriptionHTML: highlight(a.Description, params.Filter),
+			})
+		}
+
+		data["Searched"] = true
+		data["Results"] = results
+		data["Total"] = total
+		data["First"] = int(params.Offset) + 1
+		data["Last"] = int(params.Offset) + len(results)
+		data["Pages"], data["Prev"], data["Next"] = pagination(form, total)
+		renderPage(c, http.StatusOK, "search.html", data)
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlight(t *testing.T) {
+	assert.Equal(t, template.HTML("&lt;b&gt;Vote&lt;/b&gt;"), highlight("<b>Vote</b>", ""))
+	assert.Equal(t, template.HTML("<mark>Vote</mark>s &amp; <mark>vote</mark>rs"), highlight("Votes & voters", "vote"))
+	assert.Equal(t, template.HTML("<mark>Выборы</mark> прошли"), highlight("Выборы прошли", "выборы"))
+	assert.Equal(t, template.HTML("1 <mark>+</mark> 1"), highlight("1 + 1", "+"))
+}
+
+func TestSearchPageParams(t *testing.T) {
+	params, err := searchPageParams{Filter: " vote ", From: "2024-03-01", To: "2024-03-02", Page: 3}.articleParams()
+	assert.Nil(t, err)
+	assert.Equal(t, "vote", params.Filter)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), params.DateStart)
+	assert.Equal(t, time.Date(2024, 3, 2, 23, 59, 59, 0, time.UTC), params.DateEnd)
+	assert.Equal(t, uint64(40), params.Offset)
+
+	_, err = searchPageParams{From: "yesterday"}.articleParams()
+	assert.NotNil(t, err)
+}
+
+func TestPagination(t *testing.T) {
+	pages, prev, next := pagination(searchPageParams{Filter: "vote", Page: 5}, searchPageSize*10+1)
+	assert.Equal(t, []int{3, 4, 5, 6, 7}, pageNumbers(pages))
+	assert.True(t, pages[2].Current)
+	assert.Equal(t, "/search?filter=vote&page=4", prev)
+	assert.Equal(t, "/search?filter=vote&page=6", next)
+
+	pages, prev, next = pagination(searchPageParams{Filter: "vote"}, searchPageSize)
+	assert.Equal(t, []int{1}, pageNumbers(pages))
+	assert.Equal(t, "/search?filter=vote", pages[0].Href)
+	assert.Equal(t, "", prev)
+	assert.Equal(t, "", next)
+}
+
+func pageNumbers(pages []searchPageLink) []int {
+	numbers := make([]int, 0, len(pages))
+	for _, p := range pages {
+		numbers = append(numbers, p.Number)
+	}
+	return numbers
+}
+
+func TestSearchPage(t *testing.T) {
+	articles := make([]feed.Article, 0)
+	for i := 0; i < searchPageSize+5; i++ {
+		articles = append(articles, feed.Article{ID: int64(i + 1), Resource: "uk", Url: "https://uk.com/" + strconv.Itoa(i), Title: "Election results", Published: time.Now()})
+	}
+	articles = append(articles, feed.Article{ID: 100, Resource: "us", Url: "https://us.com/1", Title: "Markets", Published: time.Now()})
+	db := &filteringStorage{articles: articles}
+	holder := config.NewHolder(config.Config{Sources: []config.SourceConfig{
+		{Name: "uk", Tags: map[string][]string{"country": {"UK"}}},
+		{Name: "us", Tags: map[string][]string{"country": {"US"}}},
+	}})
+
+	r := gin.Default()
+	r.SetHTMLTemplate(template.Must(template.ParseFS(frontendFs, "templates/*.html")))
+	r.GET("/search", handleSearchPage(db, holder))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("empty form", func(t *testing.T) {
+		w := get("/search")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<option value="country:UK" >country:UK</option>`)
+		assert.NotContains(t, w.Body.String(), `<p class="search-count">`)
+	})
+
+	t.Run("results", func(t *testing.T) {
+		w := get("/search?filter=election&tags[]=country:UK&page=2")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Found 25, showing 21–25")
+		assert.Contains(t, w.Body.String(), "<mark>Election</mark> results")
+		assert.Contains(t, w.Body.String(), `<option value="country:UK" selected>country:UK</option>`)
+		assert.Contains(t, w.Body.String(), `href="/search?filter=election&amp;tags%5B%5D=country%3AUK">&larr; Previous`)
+		assert.NotContains(t, w.Body.String(), "Markets")
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		w := get("/search?resources[]=fr")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<p class="search-count">Nothing found</p>`)
+	})
+
+	t.Run("bad date", func(t *testing.T) {
+		w := get("/search?from=yesterday")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "from has to be a date")
+	})
+}
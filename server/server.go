@@ -2,16 +2,26 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/comfyprog/allnews/config"
 	"github.com/comfyprog/allnews/feed"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
 )
 
+// ErrNotFound is returned by storage when requested entity doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned by storage when a change can't be made because of existing data
+var ErrConflict = errors.New("conflict")
+
 type DbPinger interface {
 	Ping(context.Context) error
 }
@@ -37,7 +47,9 @@ type ArticleSearchParams struct {
 	Limit     uint64    `form:"limit" binding:"gte=0"`
 	Offset    uint64    `form:"offset" binding:"gte=0"`
 	Tags      []string  `form:"tags[]"`
-	Resources []string  `form:"-"`
+	Resources []string  `form:"resources[]"`
+	// Total asks api to also return the number of all matching articles
+	Total bool `form:"total"`
 }
 
 func NewArticleSearchParams() (*ArticleSearchParams, error) {
@@ -102,11 +114,80 @@ type ArticleGetter interface {
 	GetArticles(context.Context, ...GetArticleOption) ([]feed.Article, error)
 }
 
+type ArticleCounter interface {
+	CountArticles(context.Context, ...GetArticleOption) (int, error)
+}
+
+type ArticleSearcher interface {
+	ArticleGetter
+	ArticleCounter
+}
+
 type TaggedResourcesGetter interface {
 	GetResourcesWithTags([]string) ([]string, error)
 }
 
-func handleGetArticles(db ArticleGetter, config TaggedResourcesGetter) gin.HandlerFunc {
+// searchOptions turns request parameters into storage options.
+// Resources are narrowed down to the ones having all the tags.
+// found is false if no resource matches, so there is nothing to search for.
+func searchOptions(params ArticleSearchParams, config TaggedResourcesGetter) (options []GetArticleOption, found bool, err error) {
+	options = []GetArticleOption{}
+	if !params.DateStart.IsZero() {
+		options = append(options, WithDateStart(params.DateStart))
+	}
+	if !params.DateEnd.IsZero() {
+		options = append(options, WithDateEnd(params.DateEnd))
+	}
+	if params.Limit != 0 {
+		options = append(options, WithLimit(params.Limit))
+	}
+	if params.Offset != 0 {
+		options = append(options, WithOffset(params.Offset))
+	}
+	if params.Filter != "" {
+		options = append(options, WithFilter(params.Filter))
+	}
+
+	resources, found, err := resolveResources(params, config)
+	if err != nil || !found {
+		return options, false, err
+	}
+	if len(resources) > 0 {
+		options = append(options, WithResourceNames(resources))
+	}
+
+	return options, true, nil
+}
+
+// resolveResources returns requested resources narrowed down to the ones having all the tags.
+// Empty result with found set means that any resource will do.
+func resolveResources(params ArticleSearchParams, config TaggedResourcesGetter) (resources []string, found bool, err error) {
+	resources = params.Resources
+	if len(params.Tags) == 0 {
+		return resources, true, nil
+	}
+
+	tagged, err := config.GetResourcesWithTags(params.Tags)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(resources) > 0 {
+		requested := resources
+		resources = make([]string, 0, len(requested))
+		for _, name := range requested {
+			if slices.Contains(tagged, name) {
+				resources = append(resources, name)
+			}
+		}
+	} else {
+		resources = tagged
+	}
+
+	return resources, len(resources) > 0, nil
+}
+
+func handleGetArticles(db ArticleSearcher, config TaggedResourcesGetter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var params ArticleSearchParams
 		if err := c.ShouldBind(&params); err != nil {
@@ -114,85 +195,261 @@ func handleGetArticles(db ArticleGetter, config TaggedResourcesGetter) gin.Handl
 			return
 		}
 
-		options := []GetArticleOption{}
-		if !params.DateStart.IsZero() {
-			options = append(options, WithDateStart(params.DateStart))
-		}
-		if !params.DateEnd.IsZero() {
-			options = append(options, WithDateEnd(params.DateEnd))
+		options, found, err := searchOptions(params, config)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		if params.Limit != 0 {
-			options = append(options, WithLimit(params.Limit))
+
+		notFound := gin.H{"articles": []feed.Article{}}
+		if params.Total {
+			notFound["total"] = 0
 		}
-		if params.Offset != 0 {
-			options = append(options, WithOffset(params.Offset))
+		if !found {
+			c.JSON(http.StatusNotFound, notFound)
+			return
 		}
-		if params.Filter != "" {
-			options = append(options, WithFilter(params.Filter))
+
+		articles, err := db.GetArticles(c.Request.Context(), options...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 
-		if len(params.Tags) > 0 {
-			resourceNames, err := config.GetResourcesWithTags(params.Tags)
+		response := gin.H{"articles": articles}
+		if params.Total {
+			total, err := db.CountArticles(c.Request.Context(), options...)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			response["total"] = total
+			notFound["total"] = total
+		}
 
-			if len(resourceNames) == 0 {
-				c.JSON(http.StatusNotFound, gin.H{"articles": []feed.Article{}})
+		if len(articles) == 0 {
+			c.JSON(http.StatusNotFound, notFound)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+type TagsGetter interface {
+	GetAllTags() map[string][]string
+}
+
+func handleGetTags(tags TagsGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"tags": tags.GetAllTags()})
+	}
+}
+
+type SingleArticleGetter interface {
+	GetArticle(ctx context.Context, id int64) (feed.Article, error)
+}
+
+// articleWithItem is an article with the feed item it was extracted from
+type articleWithItem struct {
+	feed.Article
+	FeedItem json.RawMessage `json:"feed_item"`
+}
+
+func handleGetArticle(db SingleArticleGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "article id must be a number"})
+			return
+		}
+
+		raw := false
+		if value := c.Query("raw"); value != "" {
+			if raw, err = strconv.ParseBool(value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "raw must be a boolean"})
 				return
 			}
-
-			options = append(options, WithResourceNames(resourceNames))
 		}
 
-		articles, err := db.GetArticles(c.Request.Context(), options...)
+		article, err := db.GetArticle(c.Request.Context(), id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, err)
 			return
 		}
 
-		if len(articles) == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"articles": []feed.Article{}})
+		if !raw {
+			c.JSON(http.StatusOK, gin.H{"article": article})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"articles": articles})
+		// feed item is null if it was pruned
+		result := articleWithItem{Article: article}
+		if article.ItemJSON != "" {
+			result.FeedItem = json.RawMessage(article.ItemJSON)
+		}
+		c.JSON(http.StatusOK, gin.H{"article": result})
 	}
 }
 
-func handleGetTags(tags map[string][]string) gin.HandlerFunc {
+type HistoryGetter interface {
+	GetArticleHistory(ctx context.Context, url string) (feed.Article, []feed.ArticleRevision, error)
+}
+
+func handleGetArticleHistory(db HistoryGetter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"tags": tags})
+		url := c.Query("url")
+		if url == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url parameter is required"})
+			return
+		}
+
+		article, revisions, err := db.GetArticleHistory(c.Request.Context(), url)
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"article": article, "revisions": revisions})
 	}
 }
 
 type ServerStorage interface {
 	DbPinger
-	ArticleGetter
+	ArticleSearcher
 	StatsGetter
+	HistoryGetter
+	SingleArticleGetter
+	SourceManager
+	SourceStatusGetter
+	UserStorage
+	feed.ArticleSaver
 }
 
-func Serve(db ServerStorage, config config.Config) error {
-	// gin.SetMode(gin.ReleaseMode)
+type CollectorStatusGetter interface {
+	Status() []feed.CollectorSourceStatus
+}
+
+// handleCollectorStatus reports schedule of the collector running in the same process,
+// collector is nil if there is none
+func handleCollectorStatus(collector CollectorStatusGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if collector == nil {
+			c.JSON(http.StatusOK, gin.H{"running": false, "sources": []feed.CollectorSourceStatus{}})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"running": true, "sources": collector.Status()})
+	}
+}
+
+// Serve runs http server until ctx is done and then waits for requests in progress to finish.
+// Tags and sources are read from the holder on every request,
+// so changes made on config reload are visible without restart.
+// collector can be nil if feeds are collected by another process.
+func Serve(ctx context.Context, db ServerStorage, holder *config.Holder, collector CollectorStatusGetter, broadcaster *Broadcaster, websub WebSubReceiver) error {
+	config := holder.Get()
+
+	gin.SetMode(config.Server.Mode)
 	r := gin.Default()
+	if err := r.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return err
+	}
 
 	r.StaticFS("/static", http.FS(staticFs))
 
 	tmpl := template.Must(template.ParseFS(frontendFs, "templates/*.html"))
 	r.SetHTMLTemplate(tmpl)
 
-	r.GET("/stats", handleStatsPage(db, config.GetAllTags()))
-	r.GET("/search", handleSearchPage())
-	r.GET("/about", handleAboutPage())
+	r.Use(authenticate(db, holder))
+	r.GET("/login", handleLoginPage())
+	r.POST("/login", handleLogin(db, holder))
+	r.POST("/logout", handleLogout(db, holder))
 	r.GET("/health", handleHealth(db))
 
-	api := r.Group("/api/v1")
-	api.GET("/articles", handleGetArticles(db, config))
-	api.GET("/tags", handleGetTags(config.GetAllTags()))
+	// everything else may be hidden from anonymous visitors
+	reader := r.Group("/", requireReader(holder))
+	reader.GET("/stats", handleStatsPage(db, holder))
+	reader.GET("/search", handleSearchPage(db, holder))
+	reader.GET("/about", handleAboutPage())
+	reader.GET("/history", handleHistoryPage(db))
+
+	api := reader.Group("/api/v1")
+	api.GET("/articles", handleGetArticles(db, holder))
+	api.GET("/articles/:id", handleGetArticle(db))
+	api.GET("/tags", handleGetTags(holder))
+	api.GET("/revisions", handleGetArticleHistory(db))
+	api.GET("/stream", handleStream(broadcaster, holder, streamHeartbeat))
+
+	sources := api.Group("/sources")
+	sources.GET("", handleGetSources(db))
+	sources.GET("/:name", handleGetSource(db))
+	sources.POST("", requireAdmin(), handleCreateSource(db, holder))
+	sources.PUT("/:name", requireAdmin(), handleUpdateSource(db, holder))
+	sources.DELETE("/:name", requireAdmin(), handleDeleteSource(db))
+	sources.POST("/:name/enable", requireAdmin(), handleSetSourceEnabled(db, true))
+	sources.POST("/:name/disable", requireAdmin(), handleSetSourceEnabled(db, false))
+	sources.POST("/:name/refresh", requireAdmin(), handleRefreshSource(db, func(c *gin.Context, source feed.Source) feed.FetchResult {
+		log.Printf("Refreshing %s on request from %s", source.Name, c.ClientIP())
+		return feed.FetchSource(c.Request.Context(), source.SourceConfig, db)
+	}, newRefreshLimiter(config.Server.RefreshInterval)))
+
+	api.GET("/collector/status", handleCollectorStatus(collector))
+
+	if websub != nil {
+		r.GET(feed.WebSubCallbackPath+":source", handleWebSubVerify(websub))
+		r.POST(feed.WebSubCallbackPath+":source", handleWebSubPush(websub))
+	}
+
+	reader.GET("/", handleIndexPage(db, holder))
+
+	feeds := reader.Group("/feeds")
+	feeds.GET("/rss.xml", handleFeed(db, holder, rssFormat))
+	feeds.GET("/atom.xml", handleFeed(db, holder, atomFormat))
+	feeds.GET("/feed.json", handleFeed(db, holder, jsonFormat))
+
+	srv := &http.Server{
+		Handler:           r,
+		ReadTimeout:       config.Server.ReadTimeout,
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+	}
+	// Shutdown doesn't wait for streams to end by themselves
+	srv.RegisterOnShutdown(broadcaster.Close)
+
+	listener, err := listen(config.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		log.Printf("Shutting down, waiting for requests in progress")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}()
 
-	r.GET("/", handleIndexPage())
 	log.Printf("Listening on %s", config.ListenAddr)
+	if config.Server.TLSCert != "" {
+		err = srv.ServeTLS(listener, config.Server.TLSCert, config.Server.TLSKey)
+	} else {
+		err = srv.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 
-	return r.Run(config.ListenAddr)
+	// Serve returns as soon as Shutdown is called, before requests are finished
+	<-drained
+	return nil
 }
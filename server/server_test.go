@@ -16,6 +16,7 @@ import (
 type testStorage struct {
 	err             error
 	getArticlesData []feed.Article
+	revisions       []feed.ArticleRevision
 }
 
 func (s *testStorage) Ping(ctx context.Context) error {
@@ -30,6 +31,28 @@ func (s *testStorage) GetArticles(ctx context.Context, options ...GetArticleOpti
 	return s.getArticlesData, nil
 }
 
+func (s *testStorage) CountArticles(ctx context.Context, options ...GetArticleOption) (int, error) {
+	return len(s.getArticlesData), s.err
+}
+
+func (s *testStorage) GetArticle(ctx context.Context, id int64) (feed.Article, error) {
+	for _, a := range s.getArticlesData {
+		if a.ID == id {
+			return a, s.err
+		}
+	}
+	return feed.Article{}, ErrNotFound
+}
+
+func (s *testStorage) GetArticleHistory(ctx context.Context, url string) (feed.Article, []feed.ArticleRevision, error) {
+	for _, a := range s.getArticlesData {
+		if a.Url == url {
+			return a, s.revisions, s.err
+		}
+	}
+	return feed.Article{}, s.revisions, ErrNotFound
+}
+
 func TestHealthcheck(t *testing.T) {
 	db := &testStorage{}
 	r := gin.Default()
@@ -87,6 +110,18 @@ func TestGetArticles(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Contains(t, w.Body.String(), "title1")
+		assert.NotContains(t, w.Body.String(), "total")
+	})
+
+	t.Run("with total", func(t *testing.T) {
+		db.err = nil
+		db.getArticlesData = getArticlesData
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/articles?total=1", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"total":1`)
 	})
 
 	t.Run("with filter", func(t *testing.T) {
@@ -292,3 +327,131 @@ func TestGetArticlesWithTags(t *testing.T) {
 	})
 
 }
+
+func TestGetArticleHistory(t *testing.T) {
+	db := &testStorage{
+		getArticlesData: []feed.Article{{Resource: "test", Url: "example.com", Title: "title2", Published: time.Now()}},
+		revisions:       []feed.ArticleRevision{{Title: "title1", RevisedAt: time.Now()}},
+	}
+
+	r := gin.Default()
+	r.GET("/revisions", handleGetArticleHistory(db))
+
+	t.Run("happy path", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/revisions?url=example.com", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "title2")
+		assert.Contains(t, w.Body.String(), "title1")
+	})
+
+	t.Run("without url", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/revisions", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown url", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/revisions?url=google.com", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("storage returns err", func(t *testing.T) {
+		db.err = errors.New("storage error")
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/revisions?url=example.com", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "storage error")
+	})
+}
+
+func TestGetArticle(t *testing.T) {
+	db := &testStorage{
+		getArticlesData: []feed.Article{
+			{ID: 1, Resource: "test", Url: "example.com", Title: "title", Published: time.Now(), ItemJSON: `{"title": "raw title"}`},
+			{ID: 2, Resource: "test", Url: "example.com/pruned", Title: "pruned", Published: time.Now()},
+		},
+	}
+
+	r := gin.Default()
+	r.GET("/articles/:id", handleGetArticle(db))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		w := get("/articles/1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":1`)
+		assert.NotContains(t, w.Body.String(), "feed_item")
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		w := get("/articles/1?raw=1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"feed_item":{"title":"raw title"}`)
+
+		w = get("/articles/2?raw=true")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"feed_item":null`)
+
+		w = get("/articles/1?raw=maybe")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("bad id", func(t *testing.T) {
+		w := get("/articles/first")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		w := get("/articles/3")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+type testCollector struct{}
+
+func (testCollector) Status() []feed.CollectorSourceStatus {
+	return []feed.CollectorSourceStatus{{Name: "test", NextRun: time.Now()}}
+}
+
+func TestCollectorStatus(t *testing.T) {
+	t.Run("with collector", func(t *testing.T) {
+		r := gin.Default()
+		r.GET("/status", handleCollectorStatus(testCollector{}))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/status", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"running":true`)
+		assert.Contains(t, w.Body.String(), `"name":"test"`)
+	})
+
+	t.Run("without collector", func(t *testing.T) {
+		r := gin.Default()
+		r.GET("/status", handleCollectorStatus(nil))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/status", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"running":false`)
+	})
+}
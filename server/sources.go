@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+)
+
+type SourceManager interface {
+	GetSources(ctx context.Context) ([]feed.Source, error)
+	GetSource(ctx context.Context, name string) (feed.Source, error)
+	CreateSource(ctx context.Context, source feed.Source) error
+	UpdateSource(ctx context.Context, source config.SourceConfig) error
+	SetSourceEnabled(ctx context.Context, name string, enabled bool) error
+	DeleteSource(ctx context.Context, name string) error
+}
+
+type SourceStatusGetter interface {
+	GetSourceStatuses(ctx context.Context) (map[string]feed.SourceStatus, error)
+	GetSourceStatus(ctx context.Context, name string) (feed.SourceStatus, error)
+}
+
+// SourceLister is what listing sources with their statistics needs
+type SourceLister interface {
+	SourceManager
+	StatsGetter
+	SourceStatusGetter
+}
+
+type ConfigGetter interface {
+	Get() config.Config
+}
+
+type sourceJSON struct {
+	Name         string              `json:"name"`
+	Url          string              `json:"url"`
+	Timeout      string              `json:"timeout"`
+	UpdatePeriod string              `json:"update"`
+	Tags         map[string][]string `json:"tags"`
+	Enabled      bool                `json:"enabled"`
+	FromConfig   bool                `json:"from_config"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+	Articles     *articlesJSON       `json:"articles,omitempty"`
+	LastFetch    *lastFetchJSON      `json:"last_fetch,omitempty"`
+	Feed         *feed.FeedInfo      `json:"feed,omitempty"`
+	CollectedBy  string              `json:"collected_by,omitempty"`
+}
+
+type articlesJSON struct {
+	Total int        `json:"total"`
+	First *time.Time `json:"first"`
+	Last  *time.Time `json:"last"`
+}
+
+type lastFetchJSON struct {
+	At          time.Time  `json:"at"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	ItemsSeen   int        `json:"items_seen"`
+	Inserted    int        `json:"inserted"`
+	LastSuccess *time.Time `json:"last_success"`
+}
+
+func newSourceJSON(s feed.Source) sourceJSON {
+	tags := s.Tags
+	if tags == nil {
+		tags = map[string][]string{}
+	}
+	return sourceJSON{
+		Name:         s.Name,
+		Url:          s.FeedUrl,
+		Timeout:      s.Timeout.String(),
+		UpdatePeriod: s.UpdatePeriod.String(),
+		Tags:         tags,
+		Enabled:      s.Enabled,
+		FromConfig:   s.FromConfig,
+		UpdatedAt:    s.UpdatedAt,
+	}
+}
+
+// withStats adds article statistics and status of the last fetch to the source.
+// Sources without articles get zero total, sources never fetched get no last_fetch and feed.
+func (s sourceJSON) withStats(stats feed.ArticleStats, status *feed.SourceStatus) sourceJSON {
+	s.Articles = &articlesJSON{Total: stats.TotalArticles}
+	if stats.TotalArticles > 0 {
+		s.Articles.First = &stats.FirstDate
+		s.Articles.Last = &stats.LastDate
+	}
+	s.CollectedBy = stats.Owner
+
+	if status != nil {
+		s.LastFetch = &lastFetchJSON{
+			At:          status.LastFetchAt,
+			Status:      status.Status,
+			Error:       status.Error,
+			ItemsSeen:   status.ItemsSeen,
+			Inserted:    status.Inserted,
+			LastSuccess: status.LastSuccessAt,
+		}
+		info := status.Feed
+		s.Feed = &info
+	}
+	return s
+}
+
+// articleStatsByResource returns article statistics by resource name
+func articleStatsByResource(ctx context.Context, db StatsGetter) (map[string]feed.ArticleStats, error) {
+	stats, err := db.GetArticleStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]feed.ArticleStats, len(stats))
+	for _, s := range stats {
+		result[s.Resource] = s
+	}
+	return result, nil
+}
+
+// sourceRequest is a body of create and update requests.
+// Timeout and update period are durations like "30s" or "1h" and default to values from config.
+type sourceRequest struct {
+	Name         string              `json:"name"`
+	Url          string              `json:"url"`
+	Timeout      string              `json:"timeout"`
+	UpdatePeriod string              `json:"update"`
+	Tags         map[string][]string `json:"tags"`
+	Enabled      *bool               `json:"enabled"`
+}
+
+func (r sourceRequest) toConfig(defaults config.DefaultsConfig) (config.SourceConfig, error) {
+	source := config.SourceConfig{
+		Name:         r.Name,
+		FeedUrl:      r.Url,
+		Timeout:      defaults.Timeout,
+		UpdatePeriod: defaults.UpdatePeriod,
+		Tags:         r.Tags,
+	}
+
+	var err error
+	if r.Timeout != "" {
+		if source.Timeout, err = time.ParseDuration(r.Timeout); err != nil {
+			return source, fmt.Errorf("timeout: %w", err)
+		}
+	}
+	if r.UpdatePeriod != "" {
+		if source.UpdatePeriod, err = time.ParseDuration(r.UpdatePeriod); err != nil {
+			return source, fmt.Errorf("update: %w", err)
+		}
+	}
+
+	return source, source.Validate()
+}
+
+// respondError picks response status by the kind of error storage returned
+func respondError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// bindSource reads source from request body and responds with 400 if it's not valid
+func bindSource(c *gin.Context, cfg ConfigGetter) (sourceRequest, config.SourceConfig, bool) {
+	var req sourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, config.SourceConfig{}, false
+	}
+	if name := c.Param("name"); name != "" {
+		req.Name = name
+	}
+
+	source, err := req.toConfig(cfg.Get().Defaults)
+	var problems config.ValidationErrors
+	if errors.As(err, &problems) {
+		messages := make([]string, 0, len(problems))
+		for _, p := range problems {
+			messages = append(messages, p.Message)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid source", "problems": messages})
+		return req, source, false
+	}
+	if err == nil {
+		err = config.ValidatePublicFeedUrl(source.FeedUrl)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, source, false
+	}
+	return req, source, true
+}
+
+func handleGetSources(db SourceLister) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		sources, err := db.GetSources(ctx)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		stats, err := articleStatsByResource(ctx, db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		statuses, err := db.GetSourceStatuses(ctx)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		result := make([]sourceJSON, 0, len(sources))
+		for _, s := range sources {
+			var status *feed.SourceStatus
+			if st, ok := statuses[s.Name]; ok {
+				status = &st
+			}
+			result = append(result, newSourceJSON(s).withStats(stats[s.Name], status))
+		}
+		c.JSON(http.StatusOK, gin.H{"sources": result})
+	}
+}
+
+func handleGetSource(db SourceLister) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		source, err := db.GetSource(ctx, c.Param("name"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		stats, err := articleStatsByResource(ctx, db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var status *feed.SourceStatus
+		st, err := db.GetSourceStatus(ctx, source.Name)
+		switch {
+		case err == nil:
+			status = &st
+		case !errors.Is(err, ErrNotFound):
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"source": newSourceJSON(source).withStats(stats[source.Name], status)})
+	}
+}
+
+func handleCreateSource(db SourceManager, cfg ConfigGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, sourceConfig, ok := bindSource(c, cfg)
+		if !ok {
+			return
+		}
+
+		source := feed.Source{SourceConfig: sourceConfig, Enabled: req.Enabled == nil || *req.Enabled}
+		if err := db.CreateSource(c.Request.Context(), source); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		created, err := db.GetSource(c.Request.Context(), source.Name)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"source": newSourceJSON(created)})
+	}
+}
+
+func handleUpdateSource(db SourceManager, cfg ConfigGetter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, sourceConfig, ok := bindSource(c, cfg)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := db.UpdateSource(ctx, sourceConfig); err != nil {
+			respondError(c, err)
+			return
+		}
+		if req.Enabled != nil {
+			if err := db.SetSourceEnabled(ctx, sourceConfig.Name, *req.Enabled); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		updated, err := db.GetSource(ctx, sourceConfig.Name)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"source": newSourceJSON(updated)})
+	}
+}
+
+func handleSetSourceEnabled(db SourceManager, enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if err := db.SetSourceEnabled(ctx, c.Param("name"), enabled); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		source, err := db.GetSource(ctx, c.Param("name"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"source": newSourceJSON(source)})
+	}
+}
+
+func handleDeleteSource(db SourceManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := db.DeleteSource(c.Request.Context(), c.Param("name")); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
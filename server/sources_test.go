@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSourceStorage struct {
+	sources  map[string]feed.Source
+	stats    []feed.ArticleStats
+	statuses map[string]feed.SourceStatus
+}
+
+func (s *testSourceStorage) GetArticleStats(ctx context.Context) ([]feed.ArticleStats, error) {
+	return s.stats, nil
+}
+
+func (s *testSourceStorage) GetSourceStatuses(ctx context.Context) (map[string]feed.SourceStatus, error) {
+	return s.statuses, nil
+}
+
+func (s *testSourceStorage) GetSourceStatus(ctx context.Context, name string) (feed.SourceStatus, error) {
+	status, ok := s.statuses[name]
+	if !ok {
+		return status, ErrNotFound
+	}
+	return status, nil
+}
+
+func (s *testSourceStorage) GetSources(ctx context.Context) ([]feed.Source, error) {
+	result := make([]feed.Source, 0)
+	for _, source := range s.sources {
+		result = append(result, source)
+	}
+	return result, nil
+}
+
+func (s *testSourceStorage) GetSource(ctx context.Context, name string) (feed.Source, error) {
+	source, ok := s.sources[name]
+	if !ok {
+		return source, ErrNotFound
+	}
+	return source, nil
+}
+
+func (s *testSourceStorage) CreateSource(ctx context.Context, source feed.Source) error {
+	if _, ok := s.sources[source.Name]; ok {
+		return fmt.Errorf("source %q already exists: %w", source.Name, ErrConflict)
+	}
+	s.sources[source.Name] = source
+	return nil
+}
+
+func (s *testSourceStorage) UpdateSource(ctx context.Context, sourceConfig config.SourceConfig) error {
+	source, ok := s.sources[sourceConfig.Name]
+	if !ok {
+		return ErrNotFound
+	}
+	if source.FromConfig {
+		return ErrConflict
+	}
+	source.SourceConfig = sourceConfig
+	s.sources[source.Name] = source
+	return nil
+}
+
+func (s *testSourceStorage) SetSourceEnabled(ctx context.Context, name string, enabled bool) error {
+	source, ok := s.sources[name]
+	if !ok {
+		return ErrNotFound
+	}
+	source.Enabled = enabled
+	s.sources[name] = source
+	return nil
+}
+
+func (s *testSourceStorage) DeleteSource(ctx context.Context, name string) error {
+	source, ok := s.sources[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if source.FromConfig {
+		return ErrConflict
+	}
+	delete(s.sources, name)
+	return nil
+}
+
+type testConfigGetter struct{}
+
+func (testConfigGetter) Get() config.Config {
+	return config.Config{Defaults: config.DefaultsConfig{Timeout: time.Second * 30, UpdatePeriod: time.Hour}}
+}
+
+func TestSources(t *testing.T) {
+	db := &testSourceStorage{sources: map[string]feed.Source{
+		"config": {
+			SourceConfig: config.SourceConfig{Name: "config", FeedUrl: "https://config.com/rss", Timeout: time.Second, UpdatePeriod: time.Minute},
+			Enabled:      true,
+			FromConfig:   true,
+		},
+	}}
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.stats = []feed.ArticleStats{{Resource: "config", TotalArticles: 3, FirstDate: first, LastDate: first.Add(time.Hour)}}
+	db.statuses = map[string]feed.SourceStatus{
+		"config": {Source: "config", LastFetchAt: first.Add(time.Hour), Status: "ok", ItemsSeen: 10, Inserted: 2,
+			Feed: feed.FeedInfo{Title: "Config news", Image: "https://config.com/logo.png"}},
+	}
+
+	r := gin.Default()
+	r.GET("/sources", handleGetSources(db))
+	r.POST("/sources", handleCreateSource(db, testConfigGetter{}))
+	r.GET("/sources/:name", handleGetSource(db))
+	r.PUT("/sources/:name", handleUpdateSource(db, testConfigGetter{}))
+	r.DELETE("/sources/:name", handleDeleteSource(db))
+	r.POST("/sources/:name/disable", handleSetSourceEnabled(db, false))
+
+	do := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("create", func(t *testing.T) {
+		w := do(http.MethodPost, "/sources", `{"name": "new", "url": "https://new.com/rss", "update": "10m", "tags": {"topic": ["tech"]}}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var resp struct {
+			Source sourceJSON `json:"source"`
+		}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "30s", resp.Source.Timeout)
+		assert.Equal(t, "10m0s", resp.Source.UpdatePeriod)
+		assert.True(t, resp.Source.Enabled)
+		assert.Equal(t, []string{"tech"}, resp.Source.Tags["topic"])
+
+		w = do(http.MethodPost, "/sources", `{"name": "new", "url": "https://new.com/rss"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		w := do(http.MethodPost, "/sources", `{"name": "bad", "url": "ftp://bad", "update": "1ms"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "http://")
+		assert.Contains(t, w.Body.String(), "at least 1s")
+
+		w = do(http.MethodPost, "/sources", `{"name": "bad", "url": "https://bad.com", "timeout": "soon"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do(http.MethodPost, "/sources", `{"name": "`+strings.Repeat("л", 51)+`", "url": "https://bad.com"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "name is too long (51 characters, max 50)")
+
+		w = do(http.MethodPost, "/sources", `{"name": "bad", "url": "http://169.254.169.254/latest/meta-data/"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "non-public address")
+		assert.NotContains(t, db.sources, "bad")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		w := do(http.MethodPut, "/sources/new", `{"url": "https://new.com/atom", "enabled": false}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://new.com/atom", db.sources["new"].FeedUrl)
+		assert.False(t, db.sources["new"].Enabled)
+
+		w = do(http.MethodPut, "/sources/config", `{"url": "https://config.com/atom"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = do(http.MethodPut, "/sources/missing", `{"url": "https://missing.com/atom"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("disable config source", func(t *testing.T) {
+		w := do(http.MethodPost, "/sources/config/disable", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.False(t, db.sources["config"].Enabled)
+	})
+
+	t.Run("list and get", func(t *testing.T) {
+		w := do(http.MethodGet, "/sources", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"name":"new"`)
+		assert.Contains(t, w.Body.String(), `"from_config":true`)
+
+		w = do(http.MethodGet, "/sources/missing", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		w := do(http.MethodGet, "/sources/config", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Source sourceJSON `json:"source"`
+		}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		if assert.NotNil(t, resp.Source.Articles) {
+			assert.Equal(t, 3, resp.Source.Articles.Total)
+			assert.Equal(t, first, *resp.Source.Articles.First)
+		}
+		if assert.NotNil(t, resp.Source.LastFetch) {
+			assert.Equal(t, "ok", resp.Source.LastFetch.Status)
+			assert.Equal(t, 10, resp.Source.LastFetch.ItemsSeen)
+		}
+		if assert.NotNil(t, resp.Source.Feed) {
+			assert.Equal(t, "Config news", resp.Source.Feed.Title)
+		}
+
+		w = do(http.MethodGet, "/sources/new", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"articles":{"total":0,"first":null,"last":null}`)
+		assert.NotContains(t, w.Body.String(), "last_fetch")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := do(http.MethodDelete, "/sources/config", "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = do(http.MethodDelete, "/sources/new", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NotContains(t, db.sources, "new")
+	})
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// StreamHistorySize is how many recent articles are kept for clients resuming with Last-Event-ID
+	StreamHistorySize = 500
+	// subscriberBuffer is how many articles a subscriber may lag behind before it's disconnected
+	subscriberBuffer = 64
+	streamHeartbeat  = 15 * time.Second
+	// streamRetry is the reconnection delay suggested to clients
+	streamRetry = 5 * time.Second
+)
+
+// Broadcaster fans out newly saved articles to stream subscribers.
+// Recent articles are kept, so a reconnecting client gets what it has missed.
+// Articles are published once even if they come from several sources.
+type Broadcaster struct {
+	mu          sync.Mutex
+	history     []feed.Article
+	published   map[int64]struct{}
+	historySize int
+	subscribers map[chan feed.Article]struct{}
+	closed      bool
+}
+
+func NewBroadcaster(historySize int) *Broadcaster {
+	return &Broadcaster{
+		historySize: historySize,
+		published:   make(map[int64]struct{}),
+		subscribers: make(map[chan feed.Article]struct{}),
+	}
+}
+
+// Publish sends articles to all subscribers. Subscribers that can't keep up are disconnected,
+// they are expected to reconnect and resume from the history.
+func (b *Broadcaster) Publish(articles []feed.Article) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	sorted := make([]feed.Article, 0, len(articles))
+	for _, a := range articles {
+		if _, ok := b.published[a.ID]; !ok {
+			b.published[a.ID] = struct{}{}
+			sorted = append(sorted, a)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	b.history = append(b.history, sorted...)
+	if extra := len(b.history) - b.historySize; extra > 0 {
+		for _, a := range b.history[:extra] {
+			delete(b.published, a.ID)
+		}
+		b.history = slices.Delete(b.history, 0, extra)
+	}
+
+	for ch := range b.subscribers {
+		for _, a := range sorted {
+			select {
+			case ch <- a:
+				continue
+			default:
+			}
+			delete(b.subscribers, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// Subscribe returns kept articles newer than lastID and a channel of articles published later.
+// The channel is closed when the subscriber falls behind or the broadcaster is closed.
+func (b *Broadcaster) Subscribe(lastID int64) ([]feed.Article, <-chan feed.Article, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backlog := make([]feed.Article, 0)
+	if lastID > 0 {
+		for _, a := range b.history {
+			if a.ID > lastID {
+				backlog = append(backlog, a)
+			}
+		}
+	}
+
+	ch := make(chan feed.Article, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// Close disconnects all subscribers, so streams don't hold up server shutdown
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// articleMatcher returns a predicate for articles matching tags, resources and filter of params,
+// the way storage matches them
+func articleMatcher(params ArticleSearchParams, config TaggedResourcesGetter) (func(feed.Article) bool, error) {
+	resources, found, err := resolveResources(params, config)
+	if err != nil {
+		return nil, err
+	}
+	filter := strings.ToLower(params.Filter)
+
+	return func(a feed.Article) bool {
+		if !found {
+			return false
+		}
+		if len(resources) > 0 && !slices.Contains(resources, a.Resource) {
+			return false
+		}
+		return filter == "" || strings.Contains(strings.ToLower(a.Title), filter)
+	}, nil
+}
+
+func writeArticleEvent(w io.Writer, a feed.Article) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: article\ndata: %s\n\n", a.ID, data)
+	return err
+}
+
+// handleStream sends newly saved articles as server-sent events. Event ids are article ids,
+// a client reconnecting with Last-Event-ID gets articles it has missed if they are still kept.
+func handleStream(b *Broadcaster, config TaggedResourcesGetter, heartbeat time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params ArticleSearchParams
+		if err := c.ShouldBind(&params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		matches, err := articleMatcher(params, config)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		lastID := int64(0)
+		if header := c.GetHeader("Last-Event-ID"); header != "" {
+			if lastID, err = strconv.ParseInt(header, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be an article id"})
+				return
+			}
+		}
+
+		// the stream lasts longer than the server's write timeout allows
+		rc := http.NewResponseController(c.Writer)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		backlog, articles, cancel := b.Subscribe(lastID)
+		defer cancel()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		w := c.Writer
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds()); err != nil {
+			return
+		}
+		for _, a := range backlog {
+			if matches(a) {
+				if err := writeArticleEvent(w, a); err != nil {
+					return
+				}
+			}
+		}
+		w.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case a, ok := <-articles:
+				if !ok {
+					return
+				}
+				if !matches(a) {
+					continue
+				}
+				if err := writeArticleEvent(w, a); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+			w.Flush()
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroadcaster(t *testing.T) {
+	t.Run("resume from history", func(t *testing.T) {
+		b := NewBroadcaster(3)
+		b.Publish([]feed.Article{{ID: 2}, {ID: 1}})
+		b.Publish([]feed.Article{{ID: 3}, {ID: 4}})
+
+		backlog, _, cancel := b.Subscribe(2)
+		defer cancel()
+		assert.Equal(t, []feed.Article{{ID: 3}, {ID: 4}}, backlog)
+
+		backlog, _, cancel = b.Subscribe(0)
+		defer cancel()
+		assert.Empty(t, backlog)
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		b := NewBroadcaster(10)
+		_, articles, cancel := b.Subscribe(0)
+		defer cancel()
+
+		b.Publish([]feed.Article{{ID: 1}})
+		assert.Equal(t, feed.Article{ID: 1}, <-articles)
+
+		// the same article from another source isn't published again
+		b.Publish([]feed.Article{{ID: 1}, {ID: 2}})
+		assert.Equal(t, feed.Article{ID: 2}, <-articles)
+	})
+
+	t.Run("slow subscriber is dropped", func(t *testing.T) {
+		b := NewBroadcaster(10)
+		_, articles, cancel := b.Subscribe(0)
+		defer cancel()
+
+		for i := 0; i <= subscriberBuffer; i++ {
+			b.Publish([]feed.Article{{ID: int64(i + 1)}})
+		}
+		received := 0
+		for range articles {
+			received++
+		}
+		assert.Equal(t, subscriberBuffer, received)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		b := NewBroadcaster(10)
+		_, articles, cancel := b.Subscribe(0)
+		defer cancel()
+
+		b.Close()
+		_, ok := <-articles
+		assert.False(t, ok)
+	})
+}
+
+func TestStream(t *testing.T) {
+	holder := config.NewHolder(config.Config{Sources: []config.SourceConfig{
+		{Name: "uk", Tags: map[string][]string{"country": {"UK"}}},
+		{Name: "us", Tags: map[string][]string{"country": {"US"}}},
+	}})
+	b := NewBroadcaster(10)
+	b.Publish([]feed.Article{{ID: 1, Resource: "uk", Title: "Old news"}})
+
+	r := gin.Default()
+	r.GET("/stream", handleStream(b, holder, time.Millisecond*50))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	defer b.Close()
+
+	// events reads lines of the stream until n events or heartbeats are read
+	events := func(resp *http.Response, n int) []string {
+		read := make([]string, 0)
+		scanner := bufio.NewScanner(resp.Body)
+		var event strings.Builder
+		for len(read) < n && scanner.Scan() {
+			if scanner.Text() == "" {
+				read = append(read, event.String())
+				event.Reset()
+				continue
+			}
+			event.WriteString(scanner.Text() + "\n")
+		}
+		return read
+	}
+
+	t.Run("filtered with resume", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/stream?tags[]=country:UK&filter=NEWS", nil)
+		req.Header.Set("Last-Event-ID", "0")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		b.Publish([]feed.Article{
+			{ID: 2, Resource: "us", Title: "US news"},
+			{ID: 3, Resource: "uk", Title: "Weather"},
+			{ID: 4, Resource: "uk", Title: "UK news"},
+		})
+
+		read := events(resp, 2)
+		if assert.Len(t, read, 2) {
+			assert.Equal(t, "retry: 5000\n", read[0])
+			assert.True(t, strings.HasPrefix(read[1], "id: 4\nevent: article\ndata: {"), read[1])
+			assert.Contains(t, read[1], `"title":"UK news"`)
+		}
+
+		req, _ = http.NewRequest(http.MethodGet, ts.URL+"/stream?resources[]=uk", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		resumed, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer resumed.Body.Close()
+
+		read = events(resumed, 4)
+		if assert.Len(t, read, 4) {
+			assert.Contains(t, read[1], "id: 3\n")
+			assert.Contains(t, read[2], "id: 4\n")
+			assert.Equal(t, ": heartbeat\n", read[3])
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/stream", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodGet, "/stream?tags[]=country", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
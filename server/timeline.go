@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	timelinePageSize = 30
+	maxTimelinePage  = 100
+)
+
+// TimelineConfig provides tags for filter chips of the timeline
+type TimelineConfig interface {
+	TagsGetter
+	TaggedResourcesGetter
+}
+
+type timelineArticle struct {
+	feed.Article
+	// Ago is the publication time relative to the time the page was rendered
+	Ago string
+}
+
+// timelineDay is articles published on the same day
+type timelineDay struct {
+	Date     string
+	Label    string
+	Articles []timelineArticle
+}
+
+// tagChip is a tag filter, Href toggles it keeping the other filters
+type tagChip struct {
+	Tag    string
+	Active bool
+	Href   string
+}
+
+// relativeTime describes t like "5 minutes ago"
+func relativeTime(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// groupByDay splits articles sorted by publication time into days in the location of now
+func groupByDay(articles []feed.Article, now time.Time) []timelineDay {
+	today := now.Format(time.DateOnly)
+	yesterday := now.AddDate(0, 0, -1).Format(time.DateOnly)
+
+	days := make([]timelineDay, 0)
+	for _, a := range articles {
+		published := a.Published.In(now.Location())
+		date := published.Format(time.DateOnly)
+
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			label := published.Format("Monday, 2 January 2006")
+			switch date {
+			case today:
+				label = "Today"
+			case yesterday:
+				label = "Yesterday"
+			}
+			days = append(days, timelineDay{Date: date, Label: label})
+		}
+
+		day := &days[len(days)-1]
+		day.Articles = append(day.Articles, timelineArticle{Article: a, Ago: relativeTime(a.Published, now)})
+	}
+	return days
+}
+
+// filterQuery returns filters of params as url query, without paging
+func filterQuery(params ArticleSearchParams) url.Values {
+	query := url.Values{}
+	for _, tag := range params.Tags {
+		query.Add("tags[]", tag)
+	}
+	for _, resource := range params.Resources {
+		query.Add("resources[]", resource)
+	}
+	if params.Filter != "" {
+		query.Set("filter", params.Filter)
+	}
+	return query
+}
+
+func pageUrl(query url.Values) string {
+	if len(query) == 0 {
+		return "/"
+	}
+	return "/?" + query.Encode()
+}
+
+func tagChips(allTags map[string][]string, params ArticleSearchParams) []tagChip {
+	categories := maps.Keys(allTags)
+	slices.Sort(categories)
+
+	chips := make([]tagChip, 0)
+	for _, category := range categories {
+		values := slices.Clone(allTags[category])
+		slices.Sort(values)
+		for _, value := range values {
+			tag := category + ":" + value
+			toggled := params
+			toggled.Tags = make([]string, 0, len(params.Tags)+1)
+			active := false
+			for _, t := range params.Tags {
+				if t == tag {
+					active = true
+					continue
+				}
+				toggled.Tags = append(toggled.Tags, t)
+			}
+			if !active {
+				toggled.Tags = append(toggled.Tags, tag)
+			}
+			chips = append(chips, tagChip{Tag: tag, Active: active, Href: pageUrl(filterQuery(toggled))})
+		}
+	}
+	return chips
+}
+
+// handleIndexPage renders the timeline. With partial=1 only the list of days is rendered,
+// which is how the page loads more articles and applies filters without reloading.
+func handleIndexPage(db ArticleGetter, cfg TimelineConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		badRequest := func(err error) {
+			renderPage(c, http.StatusBadRequest, "error.html", gin.H{
+				"Url":   c.Request.URL.Path,
+				"Title": "News",
+				"Error": fmt.Sprintf("Bad request: %v", err),
+			})
+		}
+
+		var params ArticleSearchParams
+		if err := c.ShouldBind(&params); err != nil {
+			badRequest(err)
+			return
+		}
+		if params.Limit == 0 || params.Limit > maxTimelinePage {
+			params.Limit = timelinePageSize
+		}
+
+		options, found, err := searchOptions(params, cfg)
+		if err != nil {
+			badRequest(err)
+			return
+		}
+
+		articles := []feed.Article{}
+		if found {
+			articles, err = db.GetArticles(c.Request.Context(), options...)
+		}
+		if err != nil {
+			renderPage(c, http.StatusInternalServerError, "error.html", gin.H{
+				"Url":   c.Request.URL.Path,
+				"Title": "News",
+				"Error": fmt.Sprintf("Error happened: %v", err),
+			})
+			return
+		}
+
+		// feed links keep the filters, query is already encoded
+		feedQuery := template.URL("")
+		if query := filterQuery(params); len(query) > 0 {
+			feedQuery = template.URL("?" + query.Encode())
+		}
+
+		next := ""
+		if uint64(len(articles)) == params.Limit {
+			nextQuery := filterQuery(params)
+			nextQuery.Set("offset", strconv.FormatUint(params.Offset+params.Limit, 10))
+			if params.Limit != timelinePageSize {
+				nextQuery.Set("limit", strconv.FormatUint(params.Limit, 10))
+			}
+			next = pageUrl(nextQuery)
+		}
+
+		data := gin.H{
+			"Url":       c.Request.URL.Path,
+			"Title":     "News",
+			"Days":      groupByDay(articles, time.Now()),
+			"Next":      next,
+			"Chips":     tagChips(cfg.GetAllTags(), params),
+			"Tags":      params.Tags,
+			"Resources": params.Resources,
+			"Filter":    params.Filter,
+			"FeedQuery": feedQuery,
+		}
+
+		if c.Query("partial") == "1" {
+			renderPage(c, http.StatusOK, "timeline", data)
+			return
+		}
+		renderPage(c, http.StatusOK, "index.html", data)
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, "just now", relativeTime(now.Add(-time.Second*10), now))
+	assert.Equal(t, "1 minute ago", relativeTime(now.Add(-time.Minute), now))
+	assert.Equal(t, "5 hours ago", relativeTime(now.Add(-time.Hour*5-time.Minute), now))
+	assert.Equal(t, "3 days ago", relativeTime(now.Add(-time.Hour*24*3), now))
+}
+
+func TestGroupByDay(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	articles := []feed.Article{
+		{Title: "1", Published: now.Add(-time.Hour)},
+		{Title: "2", Published: now.Add(-time.Hour * 11)},
+		{Title: "3", Published: now.Add(-time.Hour * 13)},
+		{Title: "4", Published: now.Add(-time.Hour * 40)},
+	}
+
+	days := groupByDay(articles, now)
+	if assert.Len(t, days, 3) {
+		assert.Equal(t, "Today", days[0].Label)
+		assert.Len(t, days[0].Articles, 2)
+		assert.Equal(t, "Yesterday", days[1].Label)
+		assert.Equal(t, "2024-03-08", days[2].Date)
+		assert.Equal(t, "Friday, 8 March 2024", days[2].Label)
+	}
+}
+
+func TestTagChips(t *testing.T) {
+	allTags := map[string][]string{"country": {"US", "UK"}, "topic": {"politics"}}
+	chips := tagChips(allTags, ArticleSearchParams{Tags: []string{"topic:politics"}, Filter: "vote"})
+
+	expected := []tagChip{
+		{Tag: "country:UK", Href: "/?filter=vote&tags%5B%5D=topic%3Apolitics&tags%5B%5D=country%3AUK"},
+		{Tag: "country:US", Href: "/?filter=vote&tags%5B%5D=topic%3Apolitics&tags%5B%5D=country%3AUS"},
+		{Tag: "topic:politics", Active: true, Href: "/?filter=vote"},
+	}
+	assert.Equal(t, expected, chips)
+}
+
+func TestIndexPage(t *testing.T) {
+	now := time.Now()
+	articles := make([]feed.Article, 0)
+	for i := 0; i < timelinePageSize+5; i++ {
+		articles = append(articles, feed.Article{Resource: "uk", Url: "https://uk.com/" + string(rune('a'+i)), Title: "News", Published: now})
+	}
+	db := &filteringStorage{articles: articles}
+	holder := config.NewHolder(config.Config{Sources: []config.SourceConfig{
+		{Name: "uk", Tags: map[string][]string{"country": {"UK"}}},
+	}})
+
+	r := gin.Default()
+	r.SetHTMLTemplate(template.Must(template.ParseFS(frontendFs, "templates/*.html")))
+	r.GET("/", handleIndexPage(db, holder))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("full page", func(t *testing.T) {
+		w := get("/?tags[]=country:UK")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "<html>")
+		assert.Contains(t, w.Body.String(), "Today")
+		assert.Contains(t, w.Body.String(), `class="chip chip-active"`)
+		assert.Contains(t, w.Body.String(), `href="/?offset=30&amp;tags%5B%5D=country%3AUK"`)
+		assert.Contains(t, w.Body.String(), `href="/feeds/rss.xml?tags%5B%5D=country%3AUK"`)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		w := get("/?offset=30&partial=1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "<html>")
+		assert.Contains(t, w.Body.String(), "timeline-day")
+		assert.NotContains(t, w.Body.String(), "Load more")
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		w := get("/?tags[]=country:US")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "No news found")
+
+		w = get("/?tags[]=country")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+)
+
+// maxPushSize limits content pushed by hubs
+const maxPushSize = 10 << 20
+
+// WebSubReceiver answers verification requests of hubs and saves content they push
+type WebSubReceiver interface {
+	Verify(ctx context.Context, source string, v feed.Verification) (bool, error)
+	Receive(ctx context.Context, source string, signature string, body []byte) (feed.SaveResult, error)
+}
+
+// handleWebSubVerify echoes the challenge if the hub's request matches the subscription of the source
+func handleWebSubVerify(receiver WebSubReceiver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v := feed.Verification{
+			Mode:   c.Query("hub.mode"),
+			Topic:  c.Query("hub.topic"),
+			Reason: c.Query("hub.reason"),
+		}
+		challenge := c.Query("hub.challenge")
+		if v.Mode != "denied" && challenge == "" {
+			c.String(http.StatusBadRequest, "hub.challenge is required")
+			return
+		}
+		if lease := c.Query("hub.lease_seconds"); lease != "" {
+			seconds, err := strconv.Atoi(lease)
+			if err != nil {
+				c.String(http.StatusBadRequest, "hub.lease_seconds has to be a number")
+				return
+			}
+			v.Lease = time.Duration(seconds) * time.Second
+		}
+
+		confirmed, err := receiver.Verify(c.Request.Context(), c.Param("source"), v)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !confirmed {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if v.Mode == "denied" {
+			c.Status(http.StatusOK)
+			return
+		}
+		c.String(http.StatusOK, challenge)
+	}
+}
+
+// handleWebSubPush saves articles of content pushed by a hub
+func handleWebSubPush(receiver WebSubReceiver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		source := c.Param("source")
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPushSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.Status(http.StatusRequestEntityTooLarge)
+				return
+			}
+			c.Status(http.StatusBadRequest)
+			return
+		}
+
+		_, err = receiver.Receive(c.Request.Context(), source, c.GetHeader("X-Hub-Signature"), body)
+		switch {
+		case err == nil:
+			c.Status(http.StatusNoContent)
+		case errors.Is(err, feed.ErrNoSubscription):
+			// hubs drop subscriptions their subscribers report as gone
+			c.Status(http.StatusGone)
+		case errors.Is(err, feed.ErrBadSignature):
+			// the content is ignored, but has to be acknowledged so that it isn't sent again
+			log.Printf("Error: %s: ignoring content pushed from %s: %v", source, c.ClientIP(), err)
+			c.Status(http.StatusAccepted)
+		case errors.Is(err, feed.ErrBadContent):
+			c.String(http.StatusBadRequest, err.Error())
+		default:
+			log.Printf("Error: %s: saving pushed content: %v", source, err)
+			c.Status(http.StatusInternalServerError)
+		}
+	}
+}
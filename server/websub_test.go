@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type subscriptionStorage struct {
+	mu            sync.Mutex
+	subscriptions map[string]feed.Subscription
+	articles      []feed.Article
+}
+
+func (s *subscriptionStorage) SaveArticles(ctx context.Context, articles []feed.Article) (feed.SaveResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.articles = append(s.articles, articles...)
+	return feed.SaveResult{Inserted: len(articles)}, nil
+}
+
+func (s *subscriptionStorage) GetSubscription(ctx context.Context, source string) (feed.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[source]
+	if !ok {
+		return sub, feed.ErrNoSubscription
+	}
+	return sub, nil
+}
+
+func (s *subscriptionStorage) SaveSubscription(ctx context.Context, sub feed.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.Source] = sub
+	return nil
+}
+
+func (s *subscriptionStorage) DeleteSubscription(ctx context.Context, source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, source)
+	return nil
+}
+
+func (s *subscriptionStorage) savedArticles() []feed.Article {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.articles
+}
+
+const pushedFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example</title>
+  <link rel="hub" href="%s"/>
+  <link rel="self" href="%s"/>
+  <entry>
+    <title>%s</title>
+    <link href="https://example.com/%s"/>
+    <id>https://example.com/%s</id>
+    <updated>2024-03-10T12:00:00Z</updated>
+    <published>2024-03-10T12:00:00Z</published>
+  </entry>
+</feed>`
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// stubHub verifies subscriptions and pushes content like a WebSub hub does, reporting
+// status codes of callbacks it made
+type stubHub struct {
+	t        *testing.T
+	content  func(topic string) string
+	mu       sync.Mutex
+	form     url.Values
+	statuses chan int
+}
+
+func (h *stubHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	h.form = r.PostForm
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+
+	form := r.PostForm
+	go func() {
+		query := url.Values{
+			"hub.mode":          {form.Get("hub.mode")},
+			"hub.topic":         {form.Get("hub.topic")},
+			"hub.challenge":     {"challenge123"},
+			"hub.lease_seconds": {"600"},
+		}
+		resp, err := http.Get(form.Get("hub.callback") + "?" + query.Encode())
+		if !assert.NoError(h.t, err) {
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		h.statuses <- resp.StatusCode
+		if string(body) != "challenge123" {
+			return
+		}
+
+		content := h.content(form.Get("hub.topic"))
+		req, _ := http.NewRequest(http.MethodPost, form.Get("hub.callback"), strings.NewReader(content))
+		req.Header.Set("Content-Type", "application/atom+xml")
+		req.Header.Set("X-Hub-Signature", sign(form.Get("hub.secret"), content))
+		resp, err = http.DefaultClient.Do(req)
+		if !assert.NoError(h.t, err) {
+			return
+		}
+		resp.Body.Close()
+		h.statuses <- resp.StatusCode
+	}()
+}
+
+func TestWebSub(t *testing.T) {
+	storage := &subscriptionStorage{subscriptions: make(map[string]feed.Subscription)}
+
+	r := gin.Default()
+	callback := httptest.NewServer(r)
+	defer callback.Close()
+
+	websub := feed.NewWebSub(storage, config.WebSubConfig{
+		Enabled:        true,
+		CallbackUrl:    callback.URL,
+		Lease:          time.Hour * 24,
+		FallbackUpdate: time.Hour * 6,
+		// the stub hub listens on a loopback address
+		AllowPrivateHubs: true,
+	})
+	r.GET(feed.WebSubCallbackPath+":source", handleWebSubVerify(websub))
+	r.POST(feed.WebSubCallbackPath+":source", handleWebSubPush(websub))
+
+	hub := &stubHub{t: t, statuses: make(chan int, 2)}
+	hubServer := httptest.NewServer(hub)
+	defer hubServer.Close()
+
+	var feedUrl string
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, pushedFeed, hubServer.URL, feedUrl, "Polled", "polled", "polled")
+	}))
+	defer feedServer.Close()
+	feedUrl = feedServer.URL + "/feed"
+	hub.content = func(topic string) string {
+		return fmt.Sprintf(pushedFeed, hubServer.URL, topic, "Pushed", "pushed", "pushed")
+	}
+
+	source := config.SourceConfig{Name: "example", FeedUrl: feedServer.URL, Timeout: time.Second, UpdatePeriod: time.Minute}
+	ctx := context.Background()
+
+	result := feed.FetchSource(ctx, source, storage)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, feed.HubLinks{Hub: hubServer.URL, Self: feedUrl}, result.Hub)
+	assert.Equal(t, source.UpdatePeriod, websub.PollPeriod(ctx, source))
+
+	websub.Check(ctx, source, result)
+	for i := 0; i < 2; i++ {
+		select {
+		case status := <-hub.statuses:
+			assert.Less(t, status, 300)
+		case <-time.After(time.Second * 5):
+			t.Fatal("hub didn't call back")
+		}
+	}
+
+	hub.mu.Lock()
+	assert.Equal(t, callback.URL+"/websub/example", hub.form.Get("hub.callback"))
+	assert.Equal(t, feedUrl, hub.form.Get("hub.topic"))
+	assert.Equal(t, "86400", hub.form.Get("hub.lease_seconds"))
+	secret := hub.form.Get("hub.secret")
+	hub.mu.Unlock()
+
+	sub, err := storage.GetSubscription(ctx, "example")
+	assert.Nil(t, err)
+	assert.Equal(t, feed.SubscriptionActive, sub.State)
+	assert.Equal(t, time.Minute*10, sub.Lease)
+
+	// renewed before the lease granted by the hub expires
+	period := websub.PollPeriod(ctx, source)
+	assert.Greater(t, period, time.Minute*8)
+	assert.LessOrEqual(t, period, time.Minute*9)
+
+	articles := storage.savedArticles()
+	if assert.Len(t, articles, 2) {
+		assert.Equal(t, "Polled", articles[0].Title)
+		assert.Equal(t, "Pushed", articles[1].Title)
+		assert.Equal(t, "example", articles[1].Resource)
+	}
+
+	push := func(source string, signature string, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, callback.URL+"/websub/"+source, strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("bad signature is ignored", func(t *testing.T) {
+		body := hub.content(feedUrl)
+		assert.Equal(t, http.StatusAccepted, push("example", sign("wrong", body), body))
+		assert.Equal(t, http.StatusAccepted, push("example", "", body))
+		assert.Len(t, storage.savedArticles(), 2)
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		body := hub.content(feedUrl)
+		assert.Equal(t, http.StatusGone, push("unknown", sign(secret, body), body))
+	})
+
+	t.Run("not a feed", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, push("example", sign(secret, "null"), "null"))
+	})
+
+	t.Run("verification", func(t *testing.T) {
+		verify := func(query url.Values) (int, string) {
+			resp, err := http.Get(callback.URL + "/websub/example?" + query.Encode())
+			if !assert.NoError(t, err) {
+				return 0, ""
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+
+		status, _ := verify(url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://other.com"}, "hub.challenge": {"abc"}})
+		assert.Equal(t, http.StatusNotFound, status)
+
+		// still wanted
+		status, _ = verify(url.Values{"hub.mode": {"unsubscribe"}, "hub.topic": {feedUrl}, "hub.challenge": {"abc"}})
+		assert.Equal(t, http.StatusNotFound, status)
+
+		status, body := verify(url.Values{"hub.mode": {"unsubscribe"}, "hub.topic": {"https://other.com"}, "hub.challenge": {"abc"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "abc", body)
+
+		status, _ = verify(url.Values{"hub.mode": {"subscribe"}, "hub.topic": {feedUrl}})
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = verify(url.Values{"hub.mode": {"denied"}, "hub.topic": {feedUrl}, "hub.reason": {"spam"}})
+		assert.Equal(t, http.StatusOK, status)
+		sub, err := storage.GetSubscription(ctx, "example")
+		assert.Nil(t, err)
+		assert.Equal(t, feed.SubscriptionDenied, sub.State)
+		assert.Equal(t, "spam", sub.Error)
+		assert.Equal(t, source.UpdatePeriod, websub.PollPeriod(ctx, source))
+
+		// nobody asked the hub to subscribe again
+		status, _ = verify(url.Values{"hub.mode": {"subscribe"}, "hub.topic": {feedUrl}, "hub.challenge": {"abc"}})
+		assert.Equal(t, http.StatusNotFound, status)
+		sub, err = storage.GetSubscription(ctx, "example")
+		assert.Nil(t, err)
+		assert.Equal(t, feed.SubscriptionDenied, sub.State)
+	})
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/lib/pq"
+)
+
+// ErrJobLost is returned when a job can't be finished because its visibility timeout
+// expired and another worker took it
+var ErrJobLost = errors.New("job was taken over by another worker")
+
+// JobQueue is the fetch_jobs table as seen by one worker
+type JobQueue struct {
+	db                *sql.DB
+	worker            string
+	visibilityTimeout time.Duration
+}
+
+// NewJobQueue returns queue for the worker with given unique name.
+// Claimed jobs are hidden from other workers for visibilityTimeout.
+func (s *PostgresStorage) NewJobQueue(worker string, visibilityTimeout time.Duration) *JobQueue {
+	return &JobQueue{db: s.db, worker: worker, visibilityTimeout: visibilityTimeout}
+}
+
+func (q *JobQueue) Worker() string {
+	return q.worker
+}
+
+func (q *JobQueue) ClaimJobs(ctx context.Context, limit int) ([]feed.FetchJob, error) {
+	// jobs whose workers died on the last attempt won't be picked up again
+	_, err := q.db.ExecContext(ctx, `
+UPDATE fetch_jobs SET state = 'dead', last_error = 'visibility timeout expired', locked_until = NULL, finished_at = now()
+WHERE state = 'running' AND locked_until < now() AND attempts >= max_attempts`)
+	if err != nil {
+		return []feed.FetchJob{}, err
+	}
+
+	rows, err := q.db.QueryContext(ctx, `
+UPDATE fetch_jobs SET
+    state = 'running',
+    attempts = attempts + 1,
+    locked_by = $1,
+    locked_until = now() + $2::float8 * interval '1 millisecond'
+WHERE id IN (
+    SELECT id FROM fetch_jobs
+    WHERE (state = 'pending' AND run_at <= now()) OR (state = 'running' AND locked_until < now())
+    ORDER BY run_at
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING `+jobColumns, q.worker, q.visibilityTimeout.Milliseconds(), limit)
+	if err != nil {
+		return []feed.FetchJob{}, err
+	}
+
+	return scanJobs(rows)
+}
+
+func (q *JobQueue) CompleteJob(ctx context.Context, id int64) error {
+	res, err := q.db.ExecContext(ctx, `
+UPDATE fetch_jobs SET state = 'done', locked_until = NULL, finished_at = now()
+WHERE id = $1 AND locked_by = $2 AND state = 'running'`, id, q.worker)
+	return checkJobUpdated(res, err)
+}
+
+func (q *JobQueue) FailJob(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	res, err := q.db.ExecContext(ctx, `
+UPDATE fetch_jobs SET
+    state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+    finished_at = CASE WHEN attempts >= max_attempts THEN now() END,
+    run_at = now() + $3::float8 * interval '1 millisecond',
+    last_error = $4,
+    locked_until = NULL
+WHERE id = $1 AND locked_by = $2 AND state = 'running'`, id, q.worker, retryAfter.Milliseconds(), reason)
+	return checkJobUpdated(res, err)
+}
+
+func checkJobUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+const jobColumns = `id, source_name, state, attempts, max_attempts, run_at, coalesce(locked_by, ''), coalesce(last_error, ''), created_at, finished_at`
+
+func scanJobs(rows *sql.Rows) ([]feed.FetchJob, error) {
+	defer rows.Close()
+
+	result := make([]feed.FetchJob, 0)
+	for rows.Next() {
+		var j feed.FetchJob
+		var finishedAt sql.NullTime
+		err := rows.Scan(&j.ID, &j.Source, &j.State, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+			&j.LockedBy, &j.LastError, &j.CreatedAt, &finishedAt)
+		if err != nil {
+			return result, err
+		}
+		if finishedAt.Valid {
+			j.FinishedAt = &finishedAt.Time
+		}
+		result = append(result, j)
+	}
+
+	err := rows.Err()
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// EnqueueDueJobs adds jobs for sources that have no job waiting or running,
+// and whose last job was created at least update period ago. It returns number of jobs added.
+func (s *PostgresStorage) EnqueueDueJobs(ctx context.Context, sources []config.SourceConfig, maxAttempts int) (int64, error) {
+	names := make([]string, 0, len(sources))
+	periods := make([]int64, 0, len(sources))
+	for _, source := range sources {
+		names = append(names, source.Name)
+		periods = append(periods, source.UpdatePeriod.Milliseconds())
+	}
+
+	// the unique index on active jobs makes it safe to run several schedulers
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO fetch_jobs (source_name, max_attempts)
+SELECT src.name, $3 FROM unnest($1::text[], $2::bigint[]) AS src(name, period_ms)
+WHERE NOT EXISTS (
+    SELECT 1 FROM fetch_jobs j
+    WHERE j.source_name = src.name
+    AND (j.state IN ('pending', 'running') OR j.created_at > now() - src.period_ms * interval '1 millisecond')
+)
+ON CONFLICT DO NOTHING`, pq.Array(names), pq.Array(periods), maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteFinishedJobs deletes done jobs finished more than olderThan ago.
+// The latest job of every source is kept, since scheduling depends on it.
+func (s *PostgresStorage) DeleteFinishedJobs(ctx context.Context, olderThan time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM fetch_jobs j
+WHERE j.state = 'done' AND j.finished_at < now() - $1::float8 * interval '1 millisecond'
+AND EXISTS (SELECT 1 FROM fetch_jobs newer WHERE newer.source_name = j.source_name AND newer.id > j.id)`,
+		olderThan.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListJobs returns most recent jobs, optionally only ones in given state
+func (s *PostgresStorage) ListJobs(ctx context.Context, state string, limit uint64) ([]feed.FetchJob, error) {
+	query := squirrel.Select(jobColumns).From("fetch_jobs").OrderBy("id DESC").PlaceholderFormat(squirrel.Dollar)
+	if state != "" {
+		query = query.Where(squirrel.Eq{"state": state})
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		return []feed.FetchJob{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return []feed.FetchJob{}, err
+	}
+	return scanJobs(rows)
+}
+
+// RetryJobs moves dead jobs with given ids, or all dead jobs if no ids are given,
+// back to the queue with attempts reset. Only the latest dead job of a source is retried,
+// and only if the source has no job waiting or running. It returns number of jobs requeued.
+func (s *PostgresStorage) RetryJobs(ctx context.Context, ids []int64) (int64, error) {
+	if ids == nil {
+		// nil would be sent as NULL
+		ids = []int64{}
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+UPDATE fetch_jobs j SET state = 'pending', attempts = 0, run_at = now(), finished_at = NULL
+WHERE j.state = 'dead'
+AND (cardinality($1::bigint[]) = 0 OR j.id = ANY($1))
+AND NOT EXISTS (
+    SELECT 1 FROM fetch_jobs active
+    WHERE active.source_name = j.source_name AND active.state IN ('pending', 'running')
+)
+AND j.id = (SELECT max(id) FROM fetch_jobs latest WHERE latest.source_name = j.source_name AND latest.state = 'dead')`,
+		pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
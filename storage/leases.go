@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// SourceLeaser makes sure that each source is fetched by only one of the collectors
+// sharing the database. A collector owns a source while its lease is renewed,
+// leases of a collector that died expire and are taken over by others.
+type SourceLeaser struct {
+	db    *sql.DB
+	owner string
+	ttl   time.Duration
+}
+
+// NewSourceLeaser returns leaser for the collector instance named owner.
+// Owners have to be unique among running instances, e.g. host name and pid.
+func (s *PostgresStorage) NewSourceLeaser(owner string, ttl time.Duration) *SourceLeaser {
+	return &SourceLeaser{db: s.db, owner: owner, ttl: ttl}
+}
+
+func (l *SourceLeaser) Owner() string {
+	return l.owner
+}
+
+// ClaimSource takes the lease of the source if it's free or expired,
+// or extends it if it's already ours. It reports whether we own the source.
+func (l *SourceLeaser) ClaimSource(ctx context.Context, name string) (bool, error) {
+	var owner string
+	err := l.db.QueryRowContext(ctx, `
+INSERT INTO source_leases (source_name, owner, expires_at)
+VALUES ($1, $2, now() + $3::float8 * interval '1 millisecond')
+ON CONFLICT (source_name) DO UPDATE SET
+    owner = EXCLUDED.owner,
+    expires_at = EXCLUDED.expires_at,
+    acquired_at = CASE WHEN source_leases.owner = EXCLUDED.owner THEN source_leases.acquired_at ELSE now() END
+WHERE source_leases.owner = EXCLUDED.owner OR source_leases.expires_at < now()
+RETURNING owner`, name, l.owner, l.ttl.Milliseconds()).Scan(&owner)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseSource gives up the lease so that another collector can take the source right away
+func (l *SourceLeaser) ReleaseSource(ctx context.Context, name string) error {
+	_, err := l.db.ExecContext(ctx, `DELETE FROM source_leases WHERE source_name = $1 AND owner = $2`, name, l.owner)
+	return err
+}
+
+func (l *SourceLeaser) renew(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx,
+		`UPDATE source_leases SET expires_at = now() + $2::float8 * interval '1 millisecond' WHERE owner = $1`,
+		l.owner, l.ttl.Milliseconds())
+	return err
+}
+
+// Run renews our leases several times per ttl until ctx is done, and then releases all of them
+func (l *SourceLeaser) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if _, err := l.db.ExecContext(releaseCtx, `DELETE FROM source_leases WHERE owner = $1`, l.owner); err != nil {
+				log.Printf("Error: releasing source leases: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := l.renew(ctx); err != nil {
+				log.Printf("Error: renewing source leases: %v", err)
+			}
+		}
+	}
+}
@@ -1,31 +1,299 @@
 package storage
 
 import (
+	"database/sql"
 	"embed"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
 )
 
 //go:embed migrations/*.sql
-var fs embed.FS
+var migrationsFs embed.FS
 
-func Migrate(dbConnStr string) {
-	d, err := iofs.New(fs, "migrations")
+// ErrDestructiveMigration is returned when a migration would remove data
+// from a non-empty table and destructive migrations weren't allowed
+var ErrDestructiveMigration = errors.New("migration would destroy data")
+
+var destructiveStatementRe = regexp.MustCompile(
+	`(?i)(?:DROP\s+TABLE(?:\s+IF\s+EXISTS)?|TRUNCATE(?:\s+TABLE)?|DELETE\s+FROM|ALTER\s+TABLE(?:\s+IF\s+EXISTS)?)\s+([a-z_][a-z0-9_]*)(\s+DROP\s+COLUMN)?`)
+
+var (
+	dollarQuoteRe = regexp.MustCompile(`^\$(?:[A-Za-z_][A-Za-z0-9_]*)?\$`)
+	doBlockRe     = regexp.MustCompile(`(?i)\bDO\s*$`)
+)
+
+// executedSql removes comments and dollar-quoted bodies of functions from migration,
+// so that statements they define but don't run aren't taken as destructive.
+// Bodies of DO blocks are kept, since they are run right away.
+func executedSql(migration string) string {
+	var b strings.Builder
+	for i := 0; i < len(migration); {
+		rest := migration[i:]
+		switch {
+		case strings.HasPrefix(rest, "--"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			i += end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest) - 4
+			}
+			i += end + 4
+			b.WriteByte(' ')
+		case rest[0] == '\'':
+			// literals are copied as they are, so that -- or $$ inside them aren't taken for syntax
+			end := strings.IndexByte(rest[1:], '\'')
+			if end < 0 {
+				end = len(rest) - 2
+			}
+			b.WriteString(rest[:end+2])
+			i += end + 2
+		case dollarQuoteRe.MatchString(rest):
+			tag := dollarQuoteRe.FindString(rest)
+			end := strings.Index(rest[len(tag):], tag)
+			if end < 0 {
+				end = len(rest) - 2*len(tag)
+			}
+			body := rest[len(tag) : len(tag)+end]
+			if doBlockRe.MatchString(b.String()) {
+				b.WriteString(executedSql(body))
+			}
+			b.WriteByte(' ')
+			i += 2*len(tag) + end
+		default:
+			b.WriteByte(rest[0])
+			i++
+		}
+	}
+	return b.String()
+}
+
+type MigrationStatus struct {
+	Version uint
+	Dirty   bool
+	Applied []uint
+	Pending []uint
+}
+
+type Migrator struct {
+	m        *migrate.Migrate
+	src      source.Driver
+	db       *sql.DB
+	versions []uint
+}
+
+func NewMigrator(dbConnStr string) (*Migrator, error) {
+	src, err := iofs.New(migrationsFs, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, dbConnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", dbConnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]uint, 0)
+	v, err := src.First()
+	for err == nil {
+		versions = append(versions, v)
+		v, err = src.Next(v)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return &Migrator{m: m, src: src, db: db, versions: versions}, nil
+}
+
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	if err := m.db.Close(); err != nil {
+		return err
+	}
+	if srcErr != nil {
+		return srcErr
+	}
+	return dbErr
+}
+
+// version returns currently applied version, zero if there is none
+func (m *Migrator) version() (uint, bool, error) {
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+func (m *Migrator) Status() (MigrationStatus, error) {
+	status := MigrationStatus{Applied: []uint{}, Pending: []uint{}}
+
+	version, dirty, err := m.version()
+	if err != nil {
+		return status, err
+	}
+	status.Version = version
+	status.Dirty = dirty
+
+	for _, v := range m.versions {
+		if v <= version {
+			status.Applied = append(status.Applied, v)
+		} else {
+			status.Pending = append(status.Pending, v)
+		}
+	}
+
+	return status, nil
+}
+
+// Up applies migrations up to the given version, or all of them if it's zero
+func (m *Migrator) Up(to uint, allowDestructive bool) error {
+	version, _, err := m.version()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if to == 0 && len(m.versions) > 0 {
+		to = m.versions[len(m.versions)-1]
+	}
+	if to < version {
+		return fmt.Errorf("version %d is lower than current version %d, use down instead", to, version)
+	}
+
+	steps := make([]uint, 0)
+	for _, v := range m.versions {
+		if v > version && v <= to {
+			steps = append(steps, v)
+		}
+	}
+
+	if !allowDestructive {
+		if err := m.checkDestructive(steps, m.src.ReadUp); err != nil {
+			return err
+		}
 	}
 
-	m, err := migrate.NewWithSourceInstance("iofs", d, dbConnStr)
+	err = m.m.Migrate(to)
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// Down reverts n last applied migrations
+func (m *Migrator) Down(n int, allowDestructive bool) error {
+	status, err := m.Status()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if n > len(status.Applied) {
+		return fmt.Errorf("can't revert %d migrations, only %d are applied", n, len(status.Applied))
+	}
+
+	steps := make([]uint, 0, n)
+	for i := len(status.Applied) - 1; i >= len(status.Applied)-n; i-- {
+		steps = append(steps, status.Applied[i])
+	}
+
+	if !allowDestructive {
+		if err := m.checkDestructive(steps, m.src.ReadDown); err != nil {
+			return err
+		}
 	}
 
-	err = m.Up()
+	err = m.m.Steps(-n)
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// Force sets migration version without running migrations, which is needed to recover from dirty state
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+type migrationReader func(version uint) (io.ReadCloser, string, error)
+
+// checkDestructive returns ErrDestructiveMigration if any of the given migrations
+// drops, truncates or deletes from a table that has rows
+func (m *Migrator) checkDestructive(versions []uint, read migrationReader) error {
+	for _, v := range versions {
+		r, identifier, err := read(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, match := range destructiveStatementRe.FindAllStringSubmatch(executedSql(string(body)), -1) {
+			statement := strings.ToUpper(match[0])
+			if strings.HasPrefix(statement, "ALTER") && match[2] == "" {
+				continue
+			}
+
+			table := strings.ToLower(match[1])
+			hasRows, err := m.tableHasRows(table)
+			if err != nil {
+				return err
+			}
+
+			if hasRows {
+				return fmt.Errorf("%w: %d (%s) runs %q on non-empty table %s",
+					ErrDestructiveMigration, v, identifier, strings.Join(strings.Fields(match[0]), " "), table)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) tableHasRows(table string) (bool, error) {
+	var exists bool
+	err := m.db.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	var hasRows bool
+	err = m.db.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s)", pq.QuoteIdentifier(table))).Scan(&hasRows)
+	return hasRows, err
+}
+
+// Migrate applies all migrations that weren't applied yet
+func Migrate(dbConnStr string) error {
+	m, err := NewMigrator(dbConnStr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer m.Close()
 
+	return m.Up(0, false)
 }
@@ -0,0 +1,2 @@
+UPDATE articles SET feed_item = '{}'::jsonb WHERE feed_item IS NULL;
+ALTER TABLE articles ALTER COLUMN feed_item SET NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE articles ALTER COLUMN feed_item DROP NOT NULL;
@@ -0,0 +1,32 @@
+ALTER TABLE articles RENAME TO articles_partitioned;
+ALTER TABLE articles_partitioned RENAME CONSTRAINT articles_pkey TO articles_partitioned_pkey;
+ALTER SEQUENCE articles_id_seq OWNED BY NONE;
+DROP INDEX IF EXISTS published_idx;
+DROP INDEX IF EXISTS resource_name_idx;
+DROP INDEX IF EXISTS title_idx;
+DROP INDEX IF EXISTS url_idx;
+
+CREATE TABLE articles (
+    id INTEGER PRIMARY KEY DEFAULT nextval('articles_id_seq'),
+    resource_name VARCHAR(50) NOT NULL,
+    url VARCHAR(500) NOT NULL UNIQUE,
+    title VARCHAR(150) NOT NULL,
+    description VARCHAR(1024),
+    published TIMESTAMP WITH TIME ZONE NOT NULL,
+    feed_item JSONB
+);
+
+CREATE INDEX IF NOT EXISTS published_idx ON articles (published);
+CREATE INDEX IF NOT EXISTS resource_name_idx ON articles (resource_name);
+CREATE INDEX IF NOT EXISTS title_idx ON articles ((lower(title)));
+
+INSERT INTO articles (id, resource_name, url, title, description, published, feed_item)
+    SELECT id, resource_name, url, title, description, published, feed_item FROM articles_partitioned
+    ON CONFLICT (url) DO NOTHING;
+
+DROP TABLE articles_partitioned;
+ALTER SEQUENCE articles_id_seq OWNED BY articles.id;
+
+DROP FUNCTION IF EXISTS articles_dedupe_url();
+DROP FUNCTION IF EXISTS create_articles_partition(DATE);
+DROP TABLE IF EXISTS article_urls;
@@ -0,0 +1,74 @@
+ALTER TABLE articles RENAME TO articles_unpartitioned;
+ALTER TABLE articles_unpartitioned RENAME CONSTRAINT articles_pkey TO articles_unpartitioned_pkey;
+ALTER TABLE articles_unpartitioned RENAME CONSTRAINT articles_url_key TO articles_unpartitioned_url_key;
+ALTER SEQUENCE articles_id_seq OWNED BY NONE;
+DROP INDEX IF EXISTS published_idx;
+DROP INDEX IF EXISTS resource_name_idx;
+DROP INDEX IF EXISTS title_idx;
+
+-- unique constraints on a partitioned table have to include the partition key,
+-- so url uniqueness is kept in a separate table that outlives pruned and detached rows
+CREATE TABLE IF NOT EXISTS article_urls (
+    url VARCHAR(500) PRIMARY KEY
+);
+
+CREATE TABLE articles (
+    id INTEGER NOT NULL DEFAULT nextval('articles_id_seq'),
+    resource_name VARCHAR(50) NOT NULL,
+    url VARCHAR(500) NOT NULL,
+    title VARCHAR(150) NOT NULL,
+    description VARCHAR(1024),
+    published TIMESTAMP WITH TIME ZONE NOT NULL,
+    feed_item JSONB,
+    PRIMARY KEY (id, published)
+) PARTITION BY RANGE (published);
+
+CREATE TABLE articles_default PARTITION OF articles DEFAULT;
+
+CREATE INDEX IF NOT EXISTS published_idx ON articles (published);
+CREATE INDEX IF NOT EXISTS resource_name_idx ON articles (resource_name);
+CREATE INDEX IF NOT EXISTS title_idx ON articles ((lower(title)));
+CREATE INDEX IF NOT EXISTS url_idx ON articles (url);
+
+CREATE OR REPLACE FUNCTION create_articles_partition(month_start DATE) RETURNS TEXT AS $$
+DECLARE
+    partition_name TEXT := 'articles_' || to_char(month_start, 'YYYY_MM');
+    lower_bound TIMESTAMP WITH TIME ZONE := date_trunc('month', month_start::timestamp) AT TIME ZONE 'UTC';
+BEGIN
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF articles FOR VALUES FROM (%L) TO (%L)',
+        partition_name, lower_bound, lower_bound + INTERVAL '1 month');
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION articles_dedupe_url() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO article_urls (url) VALUES (NEW.url) ON CONFLICT (url) DO NOTHING;
+    IF NOT FOUND THEN
+        RETURN NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER articles_dedupe_url BEFORE INSERT ON articles
+    FOR EACH ROW EXECUTE FUNCTION articles_dedupe_url();
+
+DO $$
+DECLARE
+    month_start DATE;
+BEGIN
+    FOR month_start IN SELECT generate_series(
+        date_trunc('month', coalesce((SELECT min(published) FROM articles_unpartitioned), now()) AT TIME ZONE 'UTC'),
+        date_trunc('month', now() AT TIME ZONE 'UTC') + INTERVAL '3 months',
+        INTERVAL '1 month')::date
+    LOOP
+        PERFORM create_articles_partition(month_start);
+    END LOOP;
+END $$;
+
+INSERT INTO articles (id, resource_name, url, title, description, published, feed_item)
+    SELECT id, resource_name, url, title, description, published, feed_item FROM articles_unpartitioned;
+
+DROP TABLE articles_unpartitioned;
+ALTER SEQUENCE articles_id_seq OWNED BY articles.id;
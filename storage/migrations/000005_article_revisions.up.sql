@@ -0,0 +1,14 @@
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS content_hash CHAR(64);
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
+UPDATE articles SET content_hash = encode(sha256(convert_to(title || chr(10) || coalesce(description, ''), 'UTF8')), 'hex');
+
+CREATE TABLE IF NOT EXISTS article_revisions (
+    id SERIAL PRIMARY KEY,
+    article_id INTEGER NOT NULL,
+    url VARCHAR(500) NOT NULL,
+    title VARCHAR(150) NOT NULL,
+    description VARCHAR(1024),
+    content_hash CHAR(64),
+    revised_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS article_revisions_url_idx ON article_revisions (url);
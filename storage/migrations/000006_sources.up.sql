@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS sources (
+    name VARCHAR(50) PRIMARY KEY,
+    url VARCHAR(500) NOT NULL,
+    timeout_ms BIGINT NOT NULL,
+    update_ms BIGINT NOT NULL,
+    tags JSONB NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    from_config BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
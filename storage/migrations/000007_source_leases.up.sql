@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS source_leases (
+    source_name VARCHAR(50) PRIMARY KEY,
+    owner VARCHAR(255) NOT NULL,
+    acquired_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
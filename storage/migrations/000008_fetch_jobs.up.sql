@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS fetch_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    source_name VARCHAR(50) NOT NULL,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    locked_by VARCHAR(255),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS fetch_jobs_run_at_idx ON fetch_jobs (run_at) WHERE state IN ('pending', 'running');
+-- at most one job waiting or running per source, so several schedulers can enqueue safely
+CREATE UNIQUE INDEX IF NOT EXISTS fetch_jobs_active_source_idx ON fetch_jobs (source_name) WHERE state IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS fetch_jobs_source_idx ON fetch_jobs (source_name, created_at);
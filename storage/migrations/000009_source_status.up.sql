@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS source_status (
+    source_name VARCHAR(50) PRIMARY KEY,
+    last_fetch_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT,
+    items_seen INTEGER NOT NULL DEFAULT 0,
+    inserted INTEGER NOT NULL DEFAULT 0,
+    last_success_at TIMESTAMP WITH TIME ZONE,
+    feed_title TEXT,
+    feed_description TEXT,
+    feed_link TEXT,
+    feed_image TEXT
+);
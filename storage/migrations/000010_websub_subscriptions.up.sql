@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS websub_subscriptions (
+    source_name VARCHAR(50) PRIMARY KEY,
+    hub TEXT NOT NULL,
+    topic TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    lease_seconds BIGINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    requested_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION create_articles_partition(month_start DATE) RETURNS TEXT AS $$
+DECLARE
+    partition_name TEXT := 'articles_' || to_char(month_start, 'YYYY_MM');
+    lower_bound TIMESTAMP WITH TIME ZONE := date_trunc('month', month_start::timestamp) AT TIME ZONE 'UTC';
+BEGIN
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF articles FOR VALUES FROM (%L) TO (%L)',
+        partition_name, lower_bound, lower_bound + INTERVAL '1 month');
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,21 @@
+-- articles published beyond existing partitions land in the default one, and postgres
+-- refuses to create a partition while the default one has rows in its range,
+-- so such rows are moved into the new partition before it's attached
+CREATE OR REPLACE FUNCTION create_articles_partition(month_start DATE) RETURNS TEXT AS $$
+DECLARE
+    partition_name TEXT := 'articles_' || to_char(month_start, 'YYYY_MM');
+    lower_bound TIMESTAMP WITH TIME ZONE := date_trunc('month', month_start::timestamp) AT TIME ZONE 'UTC';
+    upper_bound TIMESTAMP WITH TIME ZONE := lower_bound + INTERVAL '1 month';
+BEGIN
+    IF to_regclass(quote_ident(partition_name)) IS NOT NULL THEN
+        RETURN partition_name;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I (LIKE articles INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name);
+    EXECUTE format('WITH moved AS (DELETE FROM articles_default WHERE published >= %L AND published < %L RETURNING *)
+        INSERT INTO %I SELECT * FROM moved', lower_bound, upper_bound, partition_name);
+    EXECUTE format('ALTER TABLE articles ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        partition_name, lower_bound, upper_bound);
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1 @@
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS config_origin TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/lib/pq"
+)
+
+const (
+	// newArticlesChannel gets comma separated ids of inserted articles
+	newArticlesChannel = "new_articles"
+	// notifyPayloadLimit keeps payloads under the 8000 bytes postgres allows
+	notifyPayloadLimit = 7900
+
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = time.Minute
+	// listenerCatchUpLimit is how many articles saved while disconnected are fetched after reconnecting
+	listenerCatchUpLimit = 1000
+)
+
+// notifyPayloads splits ids of articles into payloads small enough for NOTIFY
+func notifyPayloads(articles []feed.Article) []string {
+	payloads := make([]string, 0)
+	var b strings.Builder
+	for _, a := range articles {
+		id := strconv.FormatInt(a.ID, 10)
+		if b.Len() > 0 && b.Len()+len(id)+1 > notifyPayloadLimit {
+			payloads = append(payloads, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(id)
+	}
+	if b.Len() > 0 {
+		payloads = append(payloads, b.String())
+	}
+	return payloads
+}
+
+func parseNotifyPayload(payload string) ([]int64, error) {
+	ids := make([]int64, 0)
+	for _, s := range strings.Split(payload, ",") {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad article id %q in notification", s)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// notifyNewArticles lets listeners in other processes know about inserted articles
+func (s *PostgresStorage) notifyNewArticles(ctx context.Context, articles []feed.Article) error {
+	for _, payload := range notifyPayloads(articles) {
+		if _, err := s.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", newArticlesChannel, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArticleListener receives articles inserted by any process sharing the database,
+// e.g. a server learns about articles saved by a separate collector
+type ArticleListener struct {
+	storage *PostgresStorage
+	fn      func([]feed.Article)
+	// lastID is the greatest id seen, articles after it are fetched after reconnecting
+	lastID int64
+}
+
+// NewArticleListener returns listener passing new articles to fn, which is called from a single goroutine
+func (s *PostgresStorage) NewArticleListener(fn func([]feed.Article)) *ArticleListener {
+	return &ArticleListener{storage: s, fn: fn}
+}
+
+// Run listens for new articles until ctx is done, reconnecting when the connection is lost
+func (l *ArticleListener) Run(ctx context.Context) {
+	listener := pq.NewListener(l.storage.connStr, listenerMinReconnect, listenerMaxReconnect,
+		func(event pq.ListenerEventType, err error) {
+			switch event {
+			case pq.ListenerEventDisconnected:
+				log.Printf("Error: new articles listener disconnected: %v", err)
+			case pq.ListenerEventConnectionAttemptFailed:
+				log.Printf("Error: new articles listener can't connect: %v", err)
+			case pq.ListenerEventReconnected:
+				log.Printf("New articles listener reconnected")
+			}
+		})
+	defer listener.Close()
+
+	// Listen waits for a connection, closing the listener stops it
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	if err := listener.Listen(newArticlesChannel); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error: listening for new articles: %v", err)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// nil is sent after reconnecting, notifications could have been missed meanwhile
+			if n == nil {
+				l.catchUp(ctx)
+				continue
+			}
+			ids, err := parseNotifyPayload(n.Extra)
+			if err != nil {
+				log.Printf("Error: %v", err)
+				continue
+			}
+			articles, err := l.storage.getArticlesByID(ctx, ids)
+			if err != nil {
+				log.Printf("Error: fetching new articles: %v", err)
+				continue
+			}
+			l.publish(articles)
+		case <-ticker.C:
+			// detects broken connections that would otherwise go unnoticed
+			go listener.Ping()
+		}
+	}
+}
+
+func (l *ArticleListener) catchUp(ctx context.Context) {
+	if l.lastID == 0 {
+		return
+	}
+	articles, err := l.storage.getArticlesAfter(ctx, l.lastID, listenerCatchUpLimit)
+	if err != nil {
+		log.Printf("Error: fetching articles saved while disconnected: %v", err)
+		return
+	}
+	l.publish(articles)
+}
+
+func (l *ArticleListener) publish(articles []feed.Article) {
+	if len(articles) == 0 {
+		return
+	}
+	for _, a := range articles {
+		l.lastID = max(l.lastID, a.ID)
+	}
+	l.fn(articles)
+}
+
+func (s *PostgresStorage) queryArticles(ctx context.Context, builder squirrel.SelectBuilder) ([]feed.Article, error) {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	articles := make([]feed.Article, 0)
+	for rows.Next() {
+		a, err := scanArticle(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+func (s *PostgresStorage) getArticlesByID(ctx context.Context, ids []int64) ([]feed.Article, error) {
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	return s.queryArticles(ctx, psql.Select(articleColumns...).From("articles").
+		Where("id = ANY(?)", pq.Array(ids)).OrderBy("id"))
+}
+
+func (s *PostgresStorage) getArticlesAfter(ctx context.Context, id int64, limit uint64) ([]feed.Article, error) {
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	return s.queryArticles(ctx, psql.Select(articleColumns...).From("articles").
+		Where("id > ?", id).OrderBy("id").Limit(limit))
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const partitionPrefix = "articles_"
+
+type Partition struct {
+	Name       string
+	MonthStart time.Time
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("%s%04d_%02d", partitionPrefix, month.Year(), month.Month())
+}
+
+// parsePartitionName returns the first day of the month covered by monthly partition.
+// ok is false for partitions that don't follow the naming scheme, like the default one.
+func parsePartitionName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, partitionPrefix) {
+		return time.Time{}, false
+	}
+	month, err := time.Parse("2006_01", strings.TrimPrefix(name, partitionPrefix))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+// EnsurePartitions creates monthly partitions of articles table starting from the month
+// of `from` and `monthsAhead` months after it. Existing partitions are left intact.
+func (s *PostgresStorage) EnsurePartitions(ctx context.Context, from time.Time, monthsAhead int) ([]string, error) {
+	names := make([]string, 0, monthsAhead+1)
+	month := monthStart(from)
+
+	for i := 0; i <= monthsAhead; i++ {
+		var name string
+		err := s.db.QueryRowContext(ctx, "SELECT create_articles_partition($1)", month.AddDate(0, i, 0)).Scan(&name)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// ListPartitions returns monthly partitions currently attached to articles table
+func (s *PostgresStorage) ListPartitions(ctx context.Context) ([]Partition, error) {
+	query := `SELECT child.relname FROM pg_inherits
+		JOIN pg_class parent ON pg_inherits.inhparent = parent.oid
+		JOIN pg_class child ON pg_inherits.inhrelid = child.oid
+		WHERE parent.relname = 'articles' ORDER BY child.relname;`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return []Partition{}, err
+	}
+
+	defer rows.Close()
+
+	result := make([]Partition, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return result, err
+		}
+
+		month, ok := parsePartitionName(name)
+		if !ok {
+			continue
+		}
+		result = append(result, Partition{Name: name, MonthStart: month})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// DetachPartitionsBefore detaches partitions that only contain articles published before `before`.
+// Detached partitions stay in the database as regular tables unless drop is set.
+func (s *PostgresStorage) DetachPartitionsBefore(ctx context.Context, before time.Time, drop bool) ([]string, error) {
+	partitions, err := s.ListPartitions(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+
+	detached := make([]string, 0)
+	for _, p := range partitions {
+		if p.MonthStart.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+
+		name := pq.QuoteIdentifier(p.Name)
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE articles DETACH PARTITION %s", name)); err != nil {
+			return detached, err
+		}
+
+		if drop {
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", name)); err != nil {
+				return detached, err
+			}
+		}
+
+		detached = append(detached, p.Name)
+	}
+
+	return detached, nil
+}
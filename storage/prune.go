@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+)
+
+const defaultPruneBatchSize = 1000
+
+type PruneOptions struct {
+	DryRun    bool
+	BatchSize int
+	Now       time.Time
+}
+
+type PruneResult struct {
+	Resource     string
+	Expired      int64
+	OverLimit    int64
+	ItemsDropped int64
+}
+
+// PruneArticles applies retention policy to articles of a single resource.
+// Rows are deleted in batches of opts.BatchSize so that a large backlog
+// doesn't hold locks for a long time. With opts.DryRun nothing is changed
+// and the result contains the number of rows a real run would affect.
+func (s *PostgresStorage) PruneArticles(ctx context.Context, resource string, policy config.RetentionPolicy, opts PruneOptions) (PruneResult, error) {
+	result := PruneResult{Resource: resource}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultPruneBatchSize
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	// dry run counts of later steps skip rows removed by the earlier ones,
+	// null means that the step doesn't apply
+	var expiredBefore, keep interface{}
+
+	var err error
+
+	if policy.MaxAge > 0 {
+		threshold := opts.Now.Add(-policy.MaxAge)
+		expiredBefore = threshold
+		if opts.DryRun {
+			result.Expired, err = s.count(ctx,
+				`SELECT count(*) FROM articles WHERE resource_name = $1 AND published < $2`,
+				resource, threshold)
+		} else {
+			result.Expired, err = s.execInBatches(ctx,
+				`DELETE FROM articles WHERE id IN (
+					SELECT id FROM articles WHERE resource_name = $1 AND published < $2 LIMIT $3)`,
+				resource, threshold, opts.BatchSize)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if policy.MaxArticles > 0 {
+		keep = policy.MaxArticles
+		if opts.DryRun {
+			result.OverLimit, err = s.count(ctx,
+				`SELECT greatest(count(*) - $3, 0) FROM articles
+				WHERE resource_name = $1 AND ($2::timestamptz IS NULL OR published >= $2)`,
+				resource, expiredBefore, policy.MaxArticles)
+		} else {
+			result.OverLimit, err = s.execInBatches(ctx,
+				`DELETE FROM articles WHERE id IN (
+					SELECT id FROM articles WHERE resource_name = $1
+					ORDER BY published DESC OFFSET $2 LIMIT $3)`,
+				resource, policy.MaxArticles, opts.BatchSize)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if policy.DropItemAfter > 0 {
+		threshold := opts.Now.Add(-policy.DropItemAfter)
+		if opts.DryRun {
+			result.ItemsDropped, err = s.count(ctx,
+				`SELECT count(*) FROM (
+					SELECT published, feed_item FROM articles
+					WHERE resource_name = $1 AND ($2::timestamptz IS NULL OR published >= $2)
+					ORDER BY published DESC LIMIT $3::bigint) kept
+				WHERE published < $4 AND feed_item IS NOT NULL`,
+				resource, expiredBefore, keep, threshold)
+		} else {
+			result.ItemsDropped, err = s.execInBatches(ctx,
+				`UPDATE articles SET feed_item = NULL WHERE id IN (
+					SELECT id FROM articles WHERE resource_name = $1 AND published < $2 AND feed_item IS NOT NULL LIMIT $3)`,
+				resource, threshold, opts.BatchSize)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func (s *PostgresStorage) count(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
+
+// execInBatches runs query until it affects less rows than the batch size,
+// which has to be the last query argument
+func (s *PostgresStorage) execInBatches(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	batchSize := int64(args[len(args)-1].(int))
+
+	var total int64
+	for {
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return total, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+
+		total += affected
+		if affected < batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/comfyprog/allnews/server"
+)
+
+// updateChangedArticles updates saved articles which content hash differs from the incoming one.
+// Previous versions are moved to article_revisions. Returns number of updated articles.
+func (s *PostgresStorage) updateChangedArticles(ctx context.Context, articles []feed.Article) (int64, error) {
+	byUrl := make(map[string]feed.Article, len(articles))
+	urls := make([]string, 0, len(articles))
+	for _, a := range articles {
+		if _, ok := byUrl[a.Url]; !ok {
+			urls = append(urls, a.Url)
+		}
+		byUrl[a.Url] = a
+	}
+
+	values := make([]string, 0, len(urls))
+	args := make([]interface{}, 0, len(urls)*4)
+	for i, url := range urls {
+		a := byUrl[url]
+		n := i * 4
+		values = append(values, fmt.Sprintf("($%d::varchar, $%d::varchar, $%d::varchar, $%d::char(64))", n+1, n+2, n+3, n+4))
+		args = append(args, a.Url, a.Title, a.Description, a.ContentHash())
+	}
+
+	query := fmt.Sprintf(`WITH incoming (url, title, description, content_hash) AS (VALUES %s),
+	changed AS (
+		SELECT a.id, a.published, a.url, a.title, a.description, a.content_hash,
+			i.title AS new_title, i.description AS new_description, i.content_hash AS new_hash
+		FROM articles a JOIN incoming i ON a.url = i.url
+		WHERE a.content_hash IS DISTINCT FROM i.content_hash
+		FOR UPDATE OF a
+	),
+	saved AS (
+		INSERT INTO article_revisions (article_id, url, title, description, content_hash)
+		SELECT id, url, title, description, content_hash FROM changed
+	)
+	UPDATE articles SET title = changed.new_title, description = changed.new_description,
+		content_hash = changed.new_hash, updated_at = now()
+	FROM changed WHERE articles.id = changed.id AND articles.published = changed.published`,
+		strings.Join(values, ", "))
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetArticleHistory returns current version of the article with given url
+// and its previous versions starting from the most recent one
+func (s *PostgresStorage) GetArticleHistory(ctx context.Context, url string) (feed.Article, []feed.ArticleRevision, error) {
+	var a feed.Article
+	var description sql.NullString
+	var updatedAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, resource_name, url, title, description, published, updated_at FROM articles WHERE url = $1`, url).
+		Scan(&a.ID, &a.Resource, &a.Url, &a.Title, &description, &a.Published, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, []feed.ArticleRevision{}, server.ErrNotFound
+	}
+	if err != nil {
+		return a, []feed.ArticleRevision{}, err
+	}
+	a.Description = description.String
+	if updatedAt.Valid {
+		a.UpdatedAt = &updatedAt.Time
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT title, description, revised_at FROM article_revisions WHERE url = $1 ORDER BY revised_at DESC, id DESC`, url)
+	if err != nil {
+		return a, []feed.ArticleRevision{}, err
+	}
+
+	defer rows.Close()
+
+	result := make([]feed.ArticleRevision, 0)
+	for rows.Next() {
+		var r feed.ArticleRevision
+		var description sql.NullString
+		if err := rows.Scan(&r.Title, &description, &r.RevisedAt); err != nil {
+			return a, result, err
+		}
+		r.Description = description.String
+		result = append(result, r)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return a, result, err
+	}
+	return a, result, nil
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/comfyprog/allnews/config"
+	"github.com/comfyprog/allnews/feed"
+	"github.com/comfyprog/allnews/server"
+	"github.com/lib/pq"
+)
+
+const uniqueViolation = "23505"
+
+// marshalTags returns tags as a string, since lib/pq would send []byte as bytea
+func marshalTags(tags map[string][]string) (string, error) {
+	if tags == nil {
+		tags = map[string][]string{}
+	}
+	data, err := json.Marshal(tags)
+	return string(data), err
+}
+
+// SyncSources makes sources from config file the only config sources of its origin in the database.
+// Config sources of the origin that are no longer in the file are deleted, while sources of other
+// origins and sources added through api are kept. A source added through api or by another origin
+// belongs to this one if the file defines a source with the same name.
+// Sources saved before origins were tracked have an empty one and are removed by any sync.
+func (s *PostgresStorage) SyncSources(ctx context.Context, origin string, sources []config.SourceConfig) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		tags, err := marshalTags(source.Tags)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO sources (name, url, timeout_ms, update_ms, tags, from_config, config_origin)
+VALUES ($1, $2, $3, $4, $5, TRUE, $6)
+ON CONFLICT (name) DO UPDATE SET
+    url = EXCLUDED.url,
+    timeout_ms = EXCLUDED.timeout_ms,
+    update_ms = EXCLUDED.update_ms,
+    tags = EXCLUDED.tags,
+    from_config = TRUE,
+    config_origin = EXCLUDED.config_origin,
+    updated_at = now()
+WHERE (sources.url, sources.timeout_ms, sources.update_ms, sources.tags, sources.from_config, sources.config_origin)
+    IS DISTINCT FROM (EXCLUDED.url, EXCLUDED.timeout_ms, EXCLUDED.update_ms, EXCLUDED.tags, TRUE, EXCLUDED.config_origin)`,
+			source.Name, source.FeedUrl, source.Timeout.Milliseconds(), source.UpdatePeriod.Milliseconds(), tags, origin)
+		if err != nil {
+			return fmt.Errorf("saving source %s: %w", source.Name, err)
+		}
+		names = append(names, source.Name)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+DELETE FROM sources WHERE from_config AND config_origin IN ($2, '') AND NOT (name = ANY($1))`,
+		pq.Array(names), origin)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const sourceColumns = `name, url, timeout_ms, update_ms, tags, enabled, from_config, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSource(row rowScanner) (feed.Source, error) {
+	var source feed.Source
+	var timeoutMs, updateMs int64
+	var tags []byte
+
+	err := row.Scan(&source.Name, &source.FeedUrl, &timeoutMs, &updateMs, &tags,
+		&source.Enabled, &source.FromConfig, &source.UpdatedAt)
+	if err != nil {
+		return source, err
+	}
+
+	source.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	source.UpdatePeriod = time.Duration(updateMs) * time.Millisecond
+	source.PublicOnly = !source.FromConfig
+	if err := json.Unmarshal(tags, &source.Tags); err != nil {
+		return source, fmt.Errorf("tags of source %s: %w", source.Name, err)
+	}
+	return source, nil
+}
+
+// GetSources returns all sources, including disabled ones, ordered by name
+func (s *PostgresStorage) GetSources(ctx context.Context) ([]feed.Source, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sourceColumns+` FROM sources ORDER BY name`)
+	if err != nil {
+		return []feed.Source{}, err
+	}
+
+	defer rows.Close()
+
+	result := make([]feed.Source, 0)
+	for rows.Next() {
+		source, err := scanSource(rows)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, source)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (s *PostgresStorage) GetSource(ctx context.Context, name string) (feed.Source, error) {
+	source, err := scanSource(s.db.QueryRowContext(ctx, `SELECT `+sourceColumns+` FROM sources WHERE name = $1`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return source, fmt.Errorf("source %q: %w", name, server.ErrNotFound)
+	}
+	return source, err
+}
+
+func (s *PostgresStorage) CreateSource(ctx context.Context, source feed.Source) error {
+	tags, err := marshalTags(source.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO sources (name, url, timeout_ms, update_ms, tags, enabled) VALUES ($1, $2, $3, $4, $5, $6)`,
+		source.Name, source.FeedUrl, source.Timeout.Milliseconds(), source.UpdatePeriod.Milliseconds(), tags, source.Enabled)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return fmt.Errorf("source %q already exists: %w", source.Name, server.ErrConflict)
+	}
+	return err
+}
+
+// notChangedError explains why a statement changed nothing for the source
+// that can only be changed if it isn't defined in config file
+func (s *PostgresStorage) notChangedError(ctx context.Context, name string) error {
+	source, err := s.GetSource(ctx, name)
+	if err != nil {
+		return err
+	}
+	if source.FromConfig {
+		return fmt.Errorf("source %q is defined in config file: %w", name, server.ErrConflict)
+	}
+	return nil
+}
+
+// UpdateSource replaces settings of a source added through api
+func (s *PostgresStorage) UpdateSource(ctx context.Context, source config.SourceConfig) error {
+	tags, err := marshalTags(source.Tags)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+UPDATE sources SET url = $2, timeout_ms = $3, update_ms = $4, tags = $5, updated_at = now()
+WHERE name = $1 AND NOT from_config`,
+		source.Name, source.FeedUrl, source.Timeout.Milliseconds(), source.UpdatePeriod.Milliseconds(), tags)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return s.notChangedError(ctx, source.Name)
+}
+
+func (s *PostgresStorage) SetSourceEnabled(ctx context.Context, name string, enabled bool) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE sources SET enabled = $2, updated_at = now() WHERE name = $1 AND enabled <> $2`, name, enabled)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.GetSource(ctx, name)
+	return err
+}
+
+// DeleteSource deletes a source added through api. Its articles are kept.
+func (s *PostgresStorage) DeleteSource(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sources WHERE name = $1 AND NOT from_config`, name)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return s.notChangedError(ctx, name)
+}
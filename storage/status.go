@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+	"github.com/comfyprog/allnews/server"
+)
+
+const (
+	fetchStatusOk    = "ok"
+	fetchStatusError = "error"
+)
+
+// RecordFetch saves the outcome of a fetch.
+// Feed metadata and last success time are kept from the previous successful fetch if this one failed.
+func (s *PostgresStorage) RecordFetch(ctx context.Context, result feed.FetchResult) error {
+	status := fetchStatusOk
+	var errText sql.NullString
+	if result.Err != nil {
+		status = fetchStatusError
+		errText = sql.NullString{String: result.Err.Error(), Valid: true}
+	}
+
+	fetchedAt := result.FetchedAt
+	if fetchedAt.IsZero() {
+		fetchedAt = time.Now()
+	}
+
+	var info feed.FeedInfo
+	if result.Feed != nil {
+		info = *result.Feed
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO source_status (source_name, last_fetch_at, status, error, items_seen, inserted,
+    last_success_at, feed_title, feed_description, feed_link, feed_image)
+VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $4::text IS NULL THEN $2 END, $7, $8, $9, $10)
+ON CONFLICT (source_name) DO UPDATE SET
+    last_fetch_at = EXCLUDED.last_fetch_at,
+    status = EXCLUDED.status,
+    error = EXCLUDED.error,
+    items_seen = EXCLUDED.items_seen,
+    inserted = EXCLUDED.inserted,
+    last_success_at = coalesce(EXCLUDED.last_success_at, source_status.last_success_at),
+    feed_title = CASE WHEN $11 THEN EXCLUDED.feed_title ELSE source_status.feed_title END,
+    feed_description = CASE WHEN $11 THEN EXCLUDED.feed_description ELSE source_status.feed_description END,
+    feed_link = CASE WHEN $11 THEN EXCLUDED.feed_link ELSE source_status.feed_link END,
+    feed_image = CASE WHEN $11 THEN EXCLUDED.feed_image ELSE source_status.feed_image END`,
+		result.Source, fetchedAt, status, errText, result.ItemsSeen, result.Saved.Inserted,
+		info.Title, info.Description, info.Link, info.Image, result.Feed != nil)
+	return err
+}
+
+const sourceStatusColumns = `source_name, last_fetch_at, status, coalesce(error, ''), items_seen, inserted,
+    last_success_at, coalesce(feed_title, ''), coalesce(feed_description, ''), coalesce(feed_link, ''), coalesce(feed_image, '')`
+
+func scanSourceStatus(row rowScanner) (feed.SourceStatus, error) {
+	var status feed.SourceStatus
+	var lastSuccess sql.NullTime
+	err := row.Scan(&status.Source, &status.LastFetchAt, &status.Status, &status.Error, &status.ItemsSeen, &status.Inserted,
+		&lastSuccess, &status.Feed.Title, &status.Feed.Description, &status.Feed.Link, &status.Feed.Image)
+	if lastSuccess.Valid {
+		status.LastSuccessAt = &lastSuccess.Time
+	}
+	return status, err
+}
+
+// GetSourceStatuses returns status of every source that was fetched at least once, by source name
+func (s *PostgresStorage) GetSourceStatuses(ctx context.Context) (map[string]feed.SourceStatus, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sourceStatusColumns+` FROM source_status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]feed.SourceStatus)
+	for rows.Next() {
+		status, err := scanSourceStatus(rows)
+		if err != nil {
+			return result, err
+		}
+		result[status.Source] = status
+	}
+	return result, rows.Err()
+}
+
+// GetSourceStatus returns status of one source, or ErrNotFound if it was never fetched
+func (s *PostgresStorage) GetSourceStatus(ctx context.Context, name string) (feed.SourceStatus, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sourceStatusColumns+` FROM source_status WHERE source_name = $1`, name)
+	status, err := scanSourceStatus(row)
+	if err == sql.ErrNoRows {
+		return status, fmt.Errorf("status of source %q: %w", name, server.ErrNotFound)
+	}
+	return status, err
+}
@@ -3,19 +3,43 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"unicode/utf8"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/comfyprog/allnews/feed"
 	"github.com/comfyprog/allnews/server"
 	_ "github.com/lib/pq"
+	"golang.org/x/exp/slices"
 )
 
 type PostgresStorage struct {
-	db *sql.DB
+	db           *sql.DB
+	connStr      string
+	trackUpdates bool
+	onSave       func([]feed.Article)
 }
 
-func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
+type StorageOption func(*PostgresStorage)
+
+// WithUpdateTracking makes SaveArticles update already saved articles
+// if their content has changed, keeping previous versions in article_revisions table
+func WithUpdateTracking(enabled bool) StorageOption {
+	return func(s *PostgresStorage) {
+		s.trackUpdates = enabled
+	}
+}
+
+// WithSaveListener makes SaveArticles pass newly inserted articles, with their ids, to fn
+func WithSaveListener(fn func([]feed.Article)) StorageOption {
+	return func(s *PostgresStorage) {
+		s.onSave = fn
+	}
+}
+
+func NewPostgresStorage(connStr string, options ...StorageOption) (*PostgresStorage, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
@@ -25,49 +49,211 @@ func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
 		return nil, err
 	}
 
-	return &PostgresStorage{db: db}, nil
+	s := &PostgresStorage{db: db, connStr: connStr}
+	for _, f := range options {
+		f(s)
+	}
+
+	return s, nil
 }
 
 func (s *PostgresStorage) Ping(ctx context.Context) error {
 	return s.db.PingContext(ctx)
 }
 
-func (s *PostgresStorage) SaveArticles(ctx context.Context, articles []feed.Article) error {
+const (
+	// each article takes 7 query parameters while postgres allows at most 65535
+	saveChunkSize = 1000
+
+	maxResourceNameLength = 50
+	maxUrlLength          = 500
+	maxTitleLength        = 150
+	maxDescriptionLength  = 1024
+)
+
+// articleColumns are selected for articles without their raw feed items
+var articleColumns = []string{"id", "resource_name", "url", "title", "description", "published", "updated_at"}
+
+// validateArticle checks article against database schema constraints
+func validateArticle(a feed.Article) error {
+	if a.Url == "" {
+		return errors.New("empty url")
+	}
+
+	limits := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"resource name", a.Resource, maxResourceNameLength},
+		{"url", a.Url, maxUrlLength},
+		{"title", a.Title, maxTitleLength},
+		{"description", a.Description, maxDescriptionLength},
+	}
+
+	for _, l := range limits {
+		if n := utf8.RuneCountInString(l.value); n > l.max {
+			return fmt.Errorf("%s is too long (%d characters, max %d)", l.name, n, l.max)
+		}
+	}
+
+	return nil
+}
+
+// SaveArticles inserts articles in chunks. Articles with already known urls are counted
+// as duplicates. Invalid articles, as well as articles the database refuses to store,
+// are reported in the result without affecting the rest.
+func (s *PostgresStorage) SaveArticles(ctx context.Context, articles []feed.Article) (feed.SaveResult, error) {
+	result := feed.SaveResult{Rejected: []feed.RejectedArticle{}}
+
+	valid := make([]feed.Article, 0, len(articles))
+	for _, a := range articles {
+		if err := validateArticle(a); err != nil {
+			result.Rejected = append(result.Rejected, feed.RejectedArticle{Article: a, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, a)
+	}
+
+	for start := 0; start < len(valid); start += saveChunkSize {
+		end := start + saveChunkSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+
+		chunkResult, err := s.saveChunk(ctx, valid[start:end])
+		if err != nil {
+			return result, err
+		}
+		result.Add(chunkResult)
+	}
+
+	return result, nil
+}
+
+func (s *PostgresStorage) saveChunk(ctx context.Context, articles []feed.Article) (feed.SaveResult, error) {
+	result := feed.SaveResult{}
+
+	inserted, err := s.insertArticles(ctx, articles)
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, err
+		}
+
+		// find out which rows are the problem by inserting them one by one
+		inserted = inserted[:0]
+		saved := make([]feed.Article, 0, len(articles))
+		for _, a := range articles {
+			one, err := s.insertArticles(ctx, []feed.Article{a})
+			if err != nil {
+				if ctx.Err() != nil {
+					return result, err
+				}
+				result.Rejected = append(result.Rejected, feed.RejectedArticle{Article: a, Reason: err.Error()})
+				continue
+			}
+			inserted = append(inserted, one...)
+			saved = append(saved, a)
+		}
+		articles = saved
+	}
+
+	result.Inserted = len(inserted)
+	result.Duplicates = len(articles) - result.Inserted
+	if len(inserted) > 0 {
+		if err := s.notifyNewArticles(ctx, inserted); err != nil {
+			log.Printf("Error: notifying about new articles: %v", err)
+		}
+		if s.onSave != nil {
+			s.onSave(inserted)
+		}
+	}
+
+	if s.trackUpdates && result.Duplicates > 0 {
+		updated, err := s.updateChangedArticles(ctx, articles)
+		if err != nil {
+			return result, err
+		}
+		result.Updated = int(updated)
+		result.Duplicates -= result.Updated
+	}
+
+	return result, nil
+}
+
+// insertArticles returns inserted articles with their ids;
+// articles with already known urls are skipped by articles_dedupe_url trigger
+func (s *PostgresStorage) insertArticles(ctx context.Context, articles []feed.Article) ([]feed.Article, error) {
 	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
 
 	insert := psql.Insert("articles").
-		Columns("resource_name", "url", "title", "description", "published", "feed_item")
+		Columns("resource_name", "url", "title", "description", "published", "feed_item", "content_hash").
+		Suffix("RETURNING id, url")
 
+	byUrl := make(map[string]feed.Article, len(articles))
 	for _, a := range articles {
-		insert = insert.Values(a.Resource, a.Url, a.Title, a.Description, a.Published, a.ItemJSON)
+		// the trigger would keep the first of repeated urls, so only it is inserted
+		// and paired with the returned id
+		if _, ok := byUrl[a.Url]; ok {
+			continue
+		}
+		item := sql.NullString{String: a.ItemJSON, Valid: a.ItemJSON != ""}
+		insert = insert.Values(a.Resource, a.Url, a.Title, a.Description, a.Published, item, a.ContentHash())
+		byUrl[a.Url] = a
 	}
 
-	insert = insert.Suffix("ON CONFLICT (url) DO NOTHING")
-
 	query, args, err := insert.ToSql()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inserted := make([]feed.Article, 0, len(articles))
+	for rows.Next() {
+		var id int64
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			return inserted, err
+		}
+		a := byUrl[url]
+		a.ID = id
+		inserted = append(inserted, a)
+	}
+	return inserted, rows.Err()
+}
+
+func buildArticlesQuery(columns []string, options ...server.GetArticleOption) (squirrel.SelectBuilder, error) {
+	builder, searchParams, err := buildArticlesFilter(columns, options...)
+	if err != nil {
+		return builder, err
 	}
 
-	_, err = s.db.ExecContext(ctx, query, args...)
+	builder = builder.OrderBy("published DESC").Offset(searchParams.Offset)
+	if searchParams.Limit > 0 {
+		builder = builder.Limit(searchParams.Limit)
+	}
 
-	return err
+	return builder, nil
 }
 
-func (s *PostgresStorage) GetArticles(ctx context.Context, options ...server.GetArticleOption) ([]feed.Article, error) {
+// buildArticlesFilter selects articles matching options, without ordering and paging
+func buildArticlesFilter(columns []string, options ...server.GetArticleOption) (squirrel.SelectBuilder, *server.ArticleSearchParams, error) {
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+
 	searchParams, err := server.NewArticleSearchParams()
 	if err != nil {
-		return []feed.Article{}, err
+		return psql.Select(), searchParams, err
 	}
 	for _, f := range options {
 		f(searchParams)
 	}
 
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
-	builder := psql.Select("resource_name", "url", "title", "description", "published").
-		From("articles").
-		OrderBy("published DESC").
-		Limit(searchParams.Limit).Offset(searchParams.Offset)
+	builder := psql.Select(columns...).From("articles")
 
 	builder = builder.Where(squirrel.GtOrEq{"published": searchParams.DateStart})
 	builder = builder.Where(squirrel.LtOrEq{"published": searchParams.DateEnd})
@@ -80,38 +266,130 @@ func (s *PostgresStorage) GetArticles(ctx context.Context, options ...server.Get
 		builder = builder.Where(map[string]interface{}{"resource_name": searchParams.Resources})
 	}
 
+	return builder, searchParams, nil
+}
+
+func (s *PostgresStorage) GetArticles(ctx context.Context, options ...server.GetArticleOption) ([]feed.Article, error) {
+	result := make([]feed.Article, 0)
+	err := s.StreamArticles(ctx, false, func(a feed.Article) error {
+		result = append(result, a)
+		return nil
+	}, options...)
+
+	return result, err
+}
+
+// CountArticles returns the number of articles matching options, limit and offset are ignored
+func (s *PostgresStorage) CountArticles(ctx context.Context, options ...server.GetArticleOption) (int, error) {
+	builder, _, err := buildArticlesFilter([]string{"count(*)"}, options...)
+	if err != nil {
+		return 0, err
+	}
+
 	query, args, err := builder.ToSql()
 	if err != nil {
-		return []feed.Article{}, err
+		return 0, err
+	}
+
+	var count int
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// GetArticle returns the article with given id including its raw feed item,
+// which is empty if it was pruned
+func (s *PostgresStorage) GetArticle(ctx context.Context, id int64) (feed.Article, error) {
+	var a feed.Article
+	var description, item sql.NullString
+	var updatedAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, resource_name, url, title, description, published, updated_at, feed_item::text FROM articles WHERE id = $1`, id).
+		Scan(&a.ID, &a.Resource, &a.Url, &a.Title, &description, &a.Published, &updatedAt, &item)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fmt.Errorf("article %d: %w", id, server.ErrNotFound)
+	}
+	if err != nil {
+		return a, err
+	}
+
+	a.Description = description.String
+	a.ItemJSON = item.String
+	if updatedAt.Valid {
+		a.UpdatedAt = &updatedAt.Time
+	}
+	return a, nil
+}
+
+// StreamArticles calls fn for every article matching options without loading them all in memory.
+// Raw feed items are only fetched if withItems is set.
+// Zero limit option means that all matching articles are returned.
+func (s *PostgresStorage) StreamArticles(ctx context.Context, withItems bool, fn func(feed.Article) error, options ...server.GetArticleOption) error {
+	columns := slices.Clone(articleColumns)
+	if withItems {
+		columns = append(columns, "feed_item::text")
+	}
+
+	builder, err := buildArticlesQuery(columns, options...)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return err
 	}
 
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return []feed.Article{}, err
+		return err
 	}
 
 	defer rows.Close()
 
-	result := make([]feed.Article, 0)
 	for rows.Next() {
-		var a feed.Article
-		err := rows.Scan(&a.Resource, &a.Url, &a.Title, &a.Description, &a.Published)
+		a, err := scanArticle(rows, withItems)
 		if err != nil {
-			return result, err
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
 		}
-		result = append(result, a)
 	}
 
-	err = rows.Err()
-	if err != nil {
-		return result, err
+	return rows.Err()
+}
+
+// scanArticle reads a row of articleColumns, followed by the raw feed item if withItems is set
+func scanArticle(row rowScanner, withItems bool) (feed.Article, error) {
+	var a feed.Article
+	var description, item sql.NullString
+	var updatedAt sql.NullTime
+	dest := []interface{}{&a.ID, &a.Resource, &a.Url, &a.Title, &description, &a.Published, &updatedAt}
+	if withItems {
+		dest = append(dest, &item)
 	}
 
-	return result, nil
+	if err := row.Scan(dest...); err != nil {
+		return a, err
+	}
+	a.Description = description.String
+	a.ItemJSON = item.String
+	if updatedAt.Valid {
+		a.UpdatedAt = &updatedAt.Time
+	}
+	return a, nil
 }
 
 func (s *PostgresStorage) GetArticleStats(ctx context.Context) ([]feed.ArticleStats, error) {
-	query := `select resource_name, count(*) as total_articles, min(published) as first_date, max(published) as last_date from articles group by resource_name order by resource_name;`
+	query := `
+select a.resource_name, a.total_articles, a.first_date, a.last_date, coalesce(l.owner, '')
+from (
+    select resource_name, count(*) as total_articles, min(published) as first_date, max(published) as last_date
+    from articles group by resource_name
+) a
+left join source_leases l on l.source_name = a.resource_name and l.expires_at > now()
+order by a.resource_name;`
 
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
@@ -123,7 +401,7 @@ func (s *PostgresStorage) GetArticleStats(ctx context.Context) ([]feed.ArticleSt
 	result := make([]feed.ArticleStats, 0)
 	for rows.Next() {
 		var a feed.ArticleStats
-		err := rows.Scan(&a.Resource, &a.TotalArticles, &a.FirstDate, &a.LastDate)
+		err := rows.Scan(&a.Resource, &a.TotalArticles, &a.FirstDate, &a.LastDate, &a.Owner)
 		if err != nil {
 			return result, err
 		}
@@ -3,11 +3,14 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/comfyprog/allnews/config"
 	"github.com/comfyprog/allnews/feed"
 	"github.com/comfyprog/allnews/server"
 	"github.com/stretchr/testify/assert"
@@ -72,7 +75,7 @@ func prepareDb() (string, *sql.DB, clearDbFunc, error) {
 	}
 
 	clearDbFunc := func() error {
-		_, err := db.Exec("DELETE FROM articles;")
+		_, err := db.Exec("DELETE FROM articles; DELETE FROM article_urls; DELETE FROM article_revisions; DELETE FROM sources; DELETE FROM source_leases; DELETE FROM fetch_jobs; DELETE FROM source_status; DELETE FROM websub_subscriptions; DELETE FROM sessions; DELETE FROM users;")
 		return err
 	}
 
@@ -93,7 +96,12 @@ func TestMain(m *testing.M) {
 }
 
 func TestMigrate(t *testing.T) {
-	Migrate(connStr)
+	err := Migrate(connStr)
+	assert.Nil(t, err)
+
+	// nothing to do on the second run
+	err = Migrate(connStr)
+	assert.Nil(t, err)
 
 	rows, err := db.Query("select id, resource_name, url, title, description, published, feed_item from articles")
 	assert.Nil(t, err)
@@ -120,7 +128,7 @@ func TestSaveArticles(t *testing.T) {
 	storage, err := NewPostgresStorage(connStr)
 	assert.Nil(t, err)
 
-	err = storage.SaveArticles(context.Background(), articles)
+	_, err = storage.SaveArticles(context.Background(), articles)
 	assert.Nil(t, err)
 
 	var count int
@@ -142,7 +150,7 @@ func TestSaveArticlesWithConflictingFields(t *testing.T) {
 	storage, err := NewPostgresStorage(connStr)
 	assert.Nil(t, err)
 
-	err = storage.SaveArticles(context.Background(), articles)
+	_, err = storage.SaveArticles(context.Background(), articles)
 	assert.Nil(t, err)
 
 	var count int
@@ -166,7 +174,7 @@ func TestGetArticles(t *testing.T) {
 
 	ctx := context.Background()
 
-	err = storage.SaveArticles(ctx, articles)
+	_, err = storage.SaveArticles(ctx, articles)
 	assert.Nil(t, err)
 
 	t.Run("with default params", func(t *testing.T) {
@@ -224,6 +232,30 @@ func TestGetArticles(t *testing.T) {
 		assert.Equal(t, "title2", retrived[0].Title)
 		assert.Equal(t, "title1", retrived[1].Title)
 	})
+
+	t.Run("count", func(t *testing.T) {
+		count, err := storage.CountArticles(ctx, server.WithLimit(1), server.WithOffset(1))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count)
+
+		count, err = storage.CountArticles(ctx, server.WithResourceNames([]string{"resource2", "resource1"}))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("by id", func(t *testing.T) {
+		retrived, err := storage.GetArticles(ctx, server.WithLimit(1))
+		assert.Nil(t, err)
+		assert.NotZero(t, retrived[0].ID)
+
+		article, err := storage.GetArticle(ctx, retrived[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "title2", article.Title)
+		assert.Equal(t, "{}", article.ItemJSON)
+
+		_, err = storage.GetArticle(ctx, retrived[0].ID+1000)
+		assert.ErrorIs(t, err, server.ErrNotFound)
+	})
 }
 
 func TestGetArticleStats(t *testing.T) {
@@ -242,7 +274,7 @@ func TestGetArticleStats(t *testing.T) {
 
 	ctx := context.Background()
 
-	err = storage.SaveArticles(ctx, articles)
+	_, err = storage.SaveArticles(ctx, articles)
 	assert.Nil(t, err)
 
 	stats, err := storage.GetArticleStats(ctx)
@@ -262,3 +294,813 @@ func TestGetArticleStats(t *testing.T) {
 	assert.Less(t, now.In(utc).Add(time.Hour*-25).Sub(stats[1].FirstDate), time.Millisecond)
 	assert.Less(t, now.In(utc).Add(time.Hour).Sub(stats[1].LastDate), time.Millisecond)
 }
+
+func TestPruneArticles(t *testing.T) {
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	now := time.Now()
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "a.com", Title: "title1", Published: now, Description: "description1", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "b.com", Title: "title2", Published: now.Add(time.Hour * -48), Description: "description2", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "c.com", Title: "title3", Published: now.Add(time.Hour * -96), Description: "description3", ItemJSON: "{}"},
+		{Resource: "resource2", Url: "d.com", Title: "title4", Published: now.Add(time.Hour * -96), Description: "description4", ItemJSON: "{}"},
+	}
+
+	ctx := context.Background()
+
+	_, err = storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+
+	policy := config.RetentionPolicy{MaxAge: time.Hour * 72, DropItemAfter: time.Hour * 24}
+	var dryRun PruneResult
+
+	t.Run("dry run", func(t *testing.T) {
+		dryRun, err = storage.PruneArticles(ctx, "resource1", policy, PruneOptions{DryRun: true})
+		assert.Nil(t, err)
+		// the expired article isn't counted again for its feed item
+		assert.Equal(t, int64(1), dryRun.Expired)
+		assert.Equal(t, int64(1), dryRun.ItemsDropped)
+
+		var count int
+		err = db.QueryRow("select count(*) from articles;").Scan(&count)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, count)
+	})
+
+	t.Run("max age and feed items", func(t *testing.T) {
+		result, err := storage.PruneArticles(ctx, "resource1", policy, PruneOptions{BatchSize: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, dryRun, result)
+
+		var count, withItems int
+		err = db.QueryRow("select count(*), count(feed_item) from articles where resource_name = 'resource1';").Scan(&count, &withItems)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, 1, withItems)
+	})
+
+	t.Run("all steps", func(t *testing.T) {
+		_, err = storage.SaveArticles(ctx, []feed.Article{
+			{Resource: "resource3", Url: "e.com", Title: "title5", Published: now, Description: "description5", ItemJSON: "{}"},
+			{Resource: "resource3", Url: "f.com", Title: "title6", Published: now.Add(time.Hour * -48), Description: "description6", ItemJSON: "{}"},
+			{Resource: "resource3", Url: "g.com", Title: "title7", Published: now.Add(time.Hour * -60), Description: "description7", ItemJSON: "{}"},
+			{Resource: "resource3", Url: "h.com", Title: "title8", Published: now.Add(time.Hour * -96), Description: "description8", ItemJSON: "{}"},
+		})
+		assert.Nil(t, err)
+
+		policy := config.RetentionPolicy{MaxAge: time.Hour * 72, MaxArticles: 2, DropItemAfter: time.Hour * 24}
+		dryRun, err := storage.PruneArticles(ctx, "resource3", policy, PruneOptions{DryRun: true})
+		assert.Nil(t, err)
+		assert.Equal(t, PruneResult{Resource: "resource3", Expired: 1, OverLimit: 1, ItemsDropped: 1}, dryRun)
+
+		result, err := storage.PruneArticles(ctx, "resource3", policy, PruneOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, dryRun, result)
+	})
+
+	t.Run("max articles", func(t *testing.T) {
+		result, err := storage.PruneArticles(ctx, "resource1", config.RetentionPolicy{MaxArticles: 1}, PruneOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), result.OverLimit)
+
+		retrived, err := storage.GetArticles(ctx, server.WithResourceNames([]string{"resource1", "resource2"}))
+		assert.Nil(t, err)
+		assert.Len(t, retrived, 2)
+		assert.Equal(t, "title1", retrived[0].Title)
+		assert.Equal(t, "title4", retrived[1].Title)
+	})
+}
+
+func TestPartitions(t *testing.T) {
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	from := time.Date(2020, 11, 15, 0, 0, 0, 0, time.UTC)
+
+	names, err := storage.EnsurePartitions(ctx, from, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"articles_2020_11", "articles_2020_12", "articles_2021_01"}, names)
+
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "a.com", Title: "title1", Published: from, Description: "description1", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "b.com", Title: "title2", Published: from.AddDate(0, 2, 0), Description: "description2", ItemJSON: "{}"},
+	}
+
+	_, err = storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+
+	var partition string
+	err = db.QueryRow("select tableoid::regclass::text from articles where url = 'a.com'").Scan(&partition)
+	assert.Nil(t, err)
+	assert.Equal(t, "articles_2020_11", partition)
+
+	detached, err := storage.DetachPartitionsBefore(ctx, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"articles_2020_11", "articles_2020_12"}, detached)
+
+	var count int
+	err = db.QueryRow("select count(*) from articles;").Scan(&count)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// urls of detached articles are still known, so they aren't collected again
+	_, err = storage.SaveArticles(ctx, articles[:1])
+	assert.Nil(t, err)
+	err = db.QueryRow("select count(*) from articles;").Scan(&count)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestPartitionsWithFutureArticles(t *testing.T) {
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	future := time.Date(2090, 5, 10, 0, 0, 0, 0, time.UTC)
+	_, err = storage.SaveArticles(ctx, []feed.Article{
+		{Resource: "resource1", Url: "a.com", Title: "title1", Published: future, Description: "description1", ItemJSON: "{}"},
+	})
+	assert.Nil(t, err)
+
+	var partition string
+	err = db.QueryRow("select tableoid::regclass::text from articles where url = 'a.com'").Scan(&partition)
+	assert.Nil(t, err)
+	assert.Equal(t, "articles_default", partition)
+
+	// the row is moved out of the default partition when its month is created
+	names, err := storage.EnsurePartitions(ctx, future, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"articles_2090_05", "articles_2090_06"}, names)
+
+	err = db.QueryRow("select tableoid::regclass::text from articles where url = 'a.com'").Scan(&partition)
+	assert.Nil(t, err)
+	assert.Equal(t, "articles_2090_05", partition)
+
+	// new articles of the month go to the attached partition
+	_, err = storage.SaveArticles(ctx, []feed.Article{
+		{Resource: "resource1", Url: "b.com", Title: "title2", Published: future.Add(time.Hour), Description: "description2", ItemJSON: "{}"},
+	})
+	assert.Nil(t, err)
+	err = db.QueryRow("select tableoid::regclass::text from articles where url = 'b.com'").Scan(&partition)
+	assert.Nil(t, err)
+	assert.Equal(t, "articles_2090_05", partition)
+
+	_, err = db.Exec("DROP TABLE articles_2090_05, articles_2090_06")
+	assert.Nil(t, err)
+}
+
+func TestStreamArticles(t *testing.T) {
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "google.com", Title: "title1", Published: time.Now(), Description: "description1", ItemJSON: `{"item": 1}`},
+		{Resource: "resource2", Url: "yahoo.com", Title: "title2", Published: time.Now().Add(time.Hour * -1), Description: "description2"},
+	}
+
+	ctx := context.Background()
+
+	_, err = storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+
+	streamed := make([]feed.Article, 0)
+	err = storage.StreamArticles(ctx, true, func(a feed.Article) error {
+		streamed = append(streamed, a)
+		return nil
+	}, server.WithLimit(0))
+	assert.Nil(t, err)
+	assert.Len(t, streamed, 2)
+	assert.Equal(t, `{"item": 1}`, streamed[0].ItemJSON)
+	assert.Equal(t, "", streamed[1].ItemJSON)
+
+	err = storage.StreamArticles(ctx, false, func(a feed.Article) error {
+		return errors.New("stop")
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSaveArticlesWithUpdateTracking(t *testing.T) {
+	storage, err := NewPostgresStorage(connStr, WithUpdateTracking(true))
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	published := time.Now()
+
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: published, Description: "description1", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "google.com", Title: "title2", Published: published, Description: "description2", ItemJSON: "{}"},
+	}
+	_, err = storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+
+	articles[0].Title = "title1 updated"
+	result, err := storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Inserted)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, 1, result.Duplicates)
+
+	article, revisions, err := storage.GetArticleHistory(ctx, "example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "title1 updated", article.Title)
+	assert.NotNil(t, article.UpdatedAt)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, "title1", revisions[0].Title)
+
+	article, revisions, err = storage.GetArticleHistory(ctx, "google.com")
+	assert.Nil(t, err)
+	assert.Nil(t, article.UpdatedAt)
+	assert.Len(t, revisions, 0)
+
+	_, _, err = storage.GetArticleHistory(ctx, "bing.com")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+}
+
+func TestSaveArticlesResult(t *testing.T) {
+	var saved []feed.Article
+	storage, err := NewPostgresStorage(connStr, WithSaveListener(func(articles []feed.Article) {
+		saved = append(saved, articles...)
+	}))
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: time.Now(), Description: "description1", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "google.com", Title: strings.Repeat("t", 151), Published: time.Now(), Description: "description2", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "yahoo.com", Title: "title3", Published: time.Now(), Description: "description3", ItemJSON: "not json"},
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: time.Now(), Description: "description1", ItemJSON: "{}"},
+		// rss items don't have to have a title
+		{Resource: "resource1", Url: "bing.com", Title: "", Published: time.Now(), Description: "description4", ItemJSON: "{}"},
+	}
+
+	result, err := storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Inserted)
+	assert.Equal(t, 1, result.Duplicates)
+	assert.Len(t, result.Rejected, 2)
+	assert.Equal(t, "google.com", result.Rejected[0].Article.Url)
+	assert.Contains(t, result.Rejected[0].Reason, "title is too long")
+	assert.Equal(t, "yahoo.com", result.Rejected[1].Article.Url)
+	if assert.Len(t, saved, 2) {
+		assert.Equal(t, "example.com", saved[0].Url)
+		assert.NotZero(t, saved[0].ID)
+	}
+
+	result, err = storage.SaveArticles(ctx, []feed.Article{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Inserted)
+
+	many := make([]feed.Article, 0, saveChunkSize*2+10)
+	for i := 0; i < cap(many); i++ {
+		many = append(many, feed.Article{Resource: "resource2", Url: fmt.Sprintf("example.com/%d", i), Title: "title", Published: time.Now(), ItemJSON: "{}"})
+	}
+
+	result, err = storage.SaveArticles(ctx, many)
+	assert.Nil(t, err)
+	assert.Equal(t, len(many), result.Inserted)
+	assert.Len(t, result.Rejected, 0)
+	assert.Len(t, saved, len(many)+2)
+}
+
+func TestSaveArticlesRepeatedUrl(t *testing.T) {
+	var saved []feed.Article
+	storage, err := NewPostgresStorage(connStr, WithSaveListener(func(articles []feed.Article) {
+		saved = append(saved, articles...)
+	}))
+	assert.Nil(t, err)
+
+	err = clearDb()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "first", Published: time.Now(), Description: "first description", ItemJSON: "{}"},
+		{Resource: "resource1", Url: "google.com", Title: "other", Published: time.Now(), ItemJSON: "{}"},
+		{Resource: "resource1", Url: "example.com", Title: "second", Published: time.Now(), Description: "second description", ItemJSON: "{}"},
+	}
+
+	result, err := storage.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Inserted)
+	assert.Equal(t, 1, result.Duplicates)
+
+	var title string
+	err = db.QueryRow("select title from articles where url = 'example.com'").Scan(&title)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", title)
+
+	if assert.Len(t, saved, 2) {
+		for _, a := range saved {
+			stored, err := storage.GetArticle(ctx, a.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, stored.Url, a.Url)
+			assert.Equal(t, stored.Title, a.Title)
+			assert.Equal(t, stored.Description, a.Description)
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	fromFile := config.SourceConfig{
+		Name:         "file",
+		FeedUrl:      "https://file.com/rss",
+		Timeout:      time.Second * 10,
+		UpdatePeriod: time.Hour,
+		Tags:         map[string][]string{"topic": {"news"}},
+	}
+	err = storage.SyncSources(ctx, "/etc/allnews/config.yml", []config.SourceConfig{fromFile})
+	assert.Nil(t, err)
+
+	err = storage.CreateSource(ctx, feed.Source{
+		SourceConfig: config.SourceConfig{Name: "api", FeedUrl: "https://api.com/rss", Timeout: time.Second, UpdatePeriod: time.Minute},
+		Enabled:      true,
+	})
+	assert.Nil(t, err)
+
+	err = storage.CreateSource(ctx, feed.Source{SourceConfig: config.SourceConfig{Name: "api", FeedUrl: "https://api.com/rss"}})
+	assert.ErrorIs(t, err, server.ErrConflict)
+
+	sources, err := storage.GetSources(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, sources, 2)
+	assert.Equal(t, "api", sources[0].Name)
+	assert.False(t, sources[0].FromConfig)
+	assert.True(t, sources[0].PublicOnly)
+	assert.True(t, sources[1].FromConfig)
+	assert.False(t, sources[1].PublicOnly)
+	assert.True(t, sources[1].Equal(fromFile))
+
+	err = storage.UpdateSource(ctx, fromFile)
+	assert.ErrorIs(t, err, server.ErrConflict)
+	err = storage.DeleteSource(ctx, "file")
+	assert.ErrorIs(t, err, server.ErrConflict)
+	err = storage.SetSourceEnabled(ctx, "file", false)
+	assert.Nil(t, err)
+	err = storage.SetSourceEnabled(ctx, "missing", false)
+	assert.ErrorIs(t, err, server.ErrNotFound)
+
+	updated := sources[0].SourceConfig
+	updated.FeedUrl = "https://api.com/atom"
+	err = storage.UpdateSource(ctx, updated)
+	assert.Nil(t, err)
+
+	source, err := storage.GetSource(ctx, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.com/atom", source.FeedUrl)
+
+	// config files of other processes don't remove sources they don't define
+	other := config.SourceConfig{Name: "other", FeedUrl: "https://other.com/rss", Timeout: time.Second, UpdatePeriod: time.Hour}
+	err = storage.SyncSources(ctx, "/home/collector/config.yml", []config.SourceConfig{other})
+	assert.Nil(t, err)
+	_, err = storage.GetSource(ctx, "file")
+	assert.Nil(t, err)
+
+	// config sources that are no longer in the file are deleted
+	err = storage.SyncSources(ctx, "/etc/allnews/config.yml", []config.SourceConfig{})
+	assert.Nil(t, err)
+	_, err = storage.GetSource(ctx, "file")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+	_, err = storage.GetSource(ctx, "other")
+	assert.Nil(t, err)
+	err = storage.SyncSources(ctx, "/home/collector/config.yml", []config.SourceConfig{})
+	assert.Nil(t, err)
+
+	err = storage.DeleteSource(ctx, "api")
+	assert.Nil(t, err)
+	sources, err = storage.GetSources(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, sources, 0)
+}
+
+func TestRecordFetch(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	_, err = storage.GetSourceStatus(ctx, "source")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+
+	fetchedAt := time.Now().UTC().Truncate(time.Second)
+	err = storage.RecordFetch(ctx, feed.FetchResult{
+		Source:    "source",
+		FetchedAt: fetchedAt,
+		ItemsSeen: 10,
+		Saved:     feed.SaveResult{Inserted: 3},
+		Feed:      &feed.FeedInfo{Title: "Source", Description: "News", Image: "https://source.com/logo.png"},
+	})
+	assert.Nil(t, err)
+
+	err = storage.RecordFetch(ctx, feed.FetchResult{
+		Source:    "source",
+		FetchedAt: fetchedAt.Add(time.Minute),
+		Err:       errors.New("timeout"),
+	})
+	assert.Nil(t, err)
+
+	status, err := storage.GetSourceStatus(ctx, "source")
+	assert.Nil(t, err)
+	assert.Equal(t, "error", status.Status)
+	assert.Equal(t, "timeout", status.Error)
+	assert.True(t, fetchedAt.Add(time.Minute).Equal(status.LastFetchAt))
+	if assert.NotNil(t, status.LastSuccessAt) {
+		assert.True(t, fetchedAt.Equal(*status.LastSuccessAt))
+	}
+	assert.Equal(t, "Source", status.Feed.Title)
+	assert.Equal(t, "https://source.com/logo.png", status.Feed.Image)
+
+	statuses, err := storage.GetSourceStatuses(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 1)
+	assert.Contains(t, statuses, "source")
+}
+
+func TestSourceLeases(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	first := storage.NewSourceLeaser("first", time.Second)
+	second := storage.NewSourceLeaser("second", time.Second)
+
+	owned, err := first.ClaimSource(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.True(t, owned)
+
+	owned, err = second.ClaimSource(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.False(t, owned)
+
+	owned, err = first.ClaimSource(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.True(t, owned)
+
+	_, err = storage.SaveArticles(ctx, []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: time.Now(), ItemJSON: "{}"},
+	})
+	assert.Nil(t, err)
+	stats, err := storage.GetArticleStats(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", stats[0].Owner)
+
+	// lease of an instance that stopped renewing it is taken over
+	time.Sleep(time.Millisecond * 1100)
+	owned, err = second.ClaimSource(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.True(t, owned)
+
+	// releasing someone else's lease does nothing
+	err = first.ReleaseSource(ctx, "resource1")
+	assert.Nil(t, err)
+	owned, err = first.ClaimSource(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.False(t, owned)
+
+	err = second.ReleaseSource(ctx, "resource1")
+	assert.Nil(t, err)
+	owned, err = first.ClaimSource(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.True(t, owned)
+}
+
+func TestJobQueue(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	sources := []config.SourceConfig{
+		{Name: "resource1", UpdatePeriod: time.Hour},
+		{Name: "resource2", UpdatePeriod: time.Hour},
+	}
+	added, err := storage.EnqueueDueJobs(ctx, sources, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), added)
+
+	// sources with active or recent jobs aren't enqueued again
+	added, err = storage.EnqueueDueJobs(ctx, sources, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), added)
+
+	first := storage.NewJobQueue("first", time.Second)
+	second := storage.NewJobQueue("second", time.Second)
+
+	jobs, err := first.ClaimJobs(ctx, 1)
+	assert.Nil(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, 1, jobs[0].Attempts)
+
+	other, err := second.ClaimJobs(ctx, 10)
+	assert.Nil(t, err)
+	assert.Len(t, other, 1)
+	assert.NotEqual(t, jobs[0].ID, other[0].ID)
+
+	err = first.CompleteJob(ctx, jobs[0].ID)
+	assert.Nil(t, err)
+
+	// job of a worker that stopped responding becomes visible after visibility timeout
+	time.Sleep(time.Millisecond * 1100)
+	taken, err := first.ClaimJobs(ctx, 10)
+	assert.Nil(t, err)
+	assert.Len(t, taken, 1)
+	assert.Equal(t, other[0].ID, taken[0].ID)
+	assert.Equal(t, 2, taken[0].Attempts)
+
+	err = second.CompleteJob(ctx, other[0].ID)
+	assert.ErrorIs(t, err, ErrJobLost)
+
+	// last attempt failed
+	err = first.FailJob(ctx, taken[0].ID, "broken feed", 0)
+	assert.Nil(t, err)
+
+	dead, err := storage.ListJobs(ctx, feed.JobDead, 0)
+	assert.Nil(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "broken feed", dead[0].LastError)
+
+	n, err := storage.RetryJobs(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	pending, err := storage.ListJobs(ctx, feed.JobPending, 0)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 0, pending[0].Attempts)
+}
+
+func TestMigrator(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	m, err := NewMigrator(connStr)
+	assert.Nil(t, err)
+	defer m.Close()
+
+	status, err := m.Status()
+	assert.Nil(t, err)
+	assert.False(t, status.Dirty)
+	assert.Len(t, status.Pending, 0)
+	assert.Equal(t, status.Applied[len(status.Applied)-1], status.Version)
+	latest := status.Version
+
+	err = m.Down(1, false)
+	assert.Nil(t, err)
+
+	status, err = m.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{latest}, status.Pending)
+
+	err = m.Up(0, false)
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	_, err = storage.SaveArticles(context.Background(), []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: time.Now(), ItemJSON: "{}"},
+	})
+	assert.Nil(t, err)
+
+	err = m.Down(len(status.Applied)+1, false)
+	assert.ErrorIs(t, err, ErrDestructiveMigration)
+
+	status, err = m.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, latest, status.Version)
+
+	err = m.Down(100, true)
+	assert.NotNil(t, err)
+}
+
+func TestMigratorFunctionBodies(t *testing.T) {
+	executed := executedSql(`-- DELETE FROM comments
+CREATE FUNCTION f() RETURNS void AS $body$ BEGIN DELETE FROM defined; END $body$ LANGUAGE plpgsql;
+DO $$ BEGIN TRUNCATE run; END $$;
+SELECT '-- DELETE FROM quoted', $1;`)
+	assert.NotContains(t, executed, "comments")
+	assert.NotContains(t, executed, "defined")
+	assert.Contains(t, executed, "TRUNCATE run")
+	assert.Contains(t, executed, "DELETE FROM quoted")
+
+	err := clearDb()
+	assert.Nil(t, err)
+
+	m, err := NewMigrator(connStr)
+	assert.Nil(t, err)
+	defer m.Close()
+
+	status, err := m.Status()
+	assert.Nil(t, err)
+	latest := status.Version
+
+	// rows beyond existing partitions are kept in articles_default
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	_, err = storage.SaveArticles(context.Background(), []feed.Article{
+		{Resource: "resource1", Url: "example.com/future", Title: "title1", Published: time.Now().AddDate(50, 0, 0), ItemJSON: "{}"},
+	})
+	assert.Nil(t, err)
+	hasRows, err := m.tableHasRows("articles_default")
+	assert.Nil(t, err)
+	assert.True(t, hasRows)
+
+	// 000012 only redefines a function deleting from articles_default
+	err = m.Down(int(latest-11), false)
+	assert.Nil(t, err)
+	err = m.Up(0, false)
+	assert.Nil(t, err)
+
+	status, err = m.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, latest, status.Version)
+}
+
+func TestArticleListener(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	collector, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	server, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan []feed.Article, 10)
+	go server.NewArticleListener(func(articles []feed.Article) {
+		received <- articles
+	}).Run(ctx)
+	// let the listener connect
+	time.Sleep(time.Millisecond * 500)
+
+	articles := []feed.Article{
+		{Resource: "resource1", Url: "example.com", Title: "title1", Published: time.Now(), ItemJSON: "{}"},
+		{Resource: "resource1", Url: "google.com", Title: "title2", Published: time.Now(), ItemJSON: "{}"},
+	}
+	_, err = collector.SaveArticles(ctx, articles)
+	assert.Nil(t, err)
+
+	select {
+	case got := <-received:
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "example.com", got[0].Url)
+			assert.NotZero(t, got[0].ID)
+			assert.Less(t, got[0].ID, got[1].ID)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("no notification about new articles")
+	}
+
+	// duplicates aren't announced
+	_, err = collector.SaveArticles(ctx, articles[:1])
+	assert.Nil(t, err)
+	select {
+	case got := <-received:
+		t.Fatalf("unexpected notification about %v", got)
+	case <-time.After(time.Millisecond * 500):
+	}
+}
+
+func TestNotifyPayloads(t *testing.T) {
+	articles := make([]feed.Article, 0)
+	for i := 0; i < 2000; i++ {
+		articles = append(articles, feed.Article{ID: int64(1000000 + i)})
+	}
+
+	payloads := notifyPayloads(articles)
+	ids := make([]int64, 0)
+	for _, p := range payloads {
+		assert.LessOrEqual(t, len(p), notifyPayloadLimit)
+		parsed, err := parseNotifyPayload(p)
+		assert.Nil(t, err)
+		ids = append(ids, parsed...)
+	}
+	assert.Greater(t, len(payloads), 1)
+	assert.Len(t, ids, len(articles))
+	assert.Equal(t, int64(1000000), ids[0])
+
+	_, err := parseNotifyPayload("1,x")
+	assert.NotNil(t, err)
+}
+
+func TestSubscriptions(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	_, err = storage.GetSubscription(ctx, "resource1")
+	assert.ErrorIs(t, err, feed.ErrNoSubscription)
+
+	sub := feed.Subscription{
+		Source: "resource1", Hub: "https://hub.example.com", Topic: "https://example.com/feed",
+		Secret: "secret", State: feed.SubscriptionPending, RequestedAt: time.Now().Truncate(time.Second),
+	}
+	err = storage.SaveSubscription(ctx, sub)
+	assert.Nil(t, err)
+
+	got, err := storage.GetSubscription(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.Equal(t, sub.Topic, got.Topic)
+	assert.Equal(t, feed.SubscriptionPending, got.State)
+	assert.Nil(t, got.ExpiresAt)
+	assert.True(t, sub.RequestedAt.Equal(got.RequestedAt))
+
+	expires := time.Now().Add(time.Hour)
+	sub.State = feed.SubscriptionActive
+	sub.Lease = time.Hour
+	sub.ExpiresAt = &expires
+	err = storage.SaveSubscription(ctx, sub)
+	assert.Nil(t, err)
+
+	got, err = storage.GetSubscription(ctx, "resource1")
+	assert.Nil(t, err)
+	assert.Equal(t, feed.SubscriptionActive, got.State)
+	assert.Equal(t, time.Hour, got.Lease)
+	if assert.NotNil(t, got.ExpiresAt) {
+		assert.WithinDuration(t, expires, *got.ExpiresAt, time.Millisecond)
+	}
+
+	err = storage.DeleteSubscription(ctx, "resource1")
+	assert.Nil(t, err)
+	_, err = storage.GetSubscription(ctx, "resource1")
+	assert.ErrorIs(t, err, feed.ErrNoSubscription)
+}
+
+func TestUsers(t *testing.T) {
+	err := clearDb()
+	assert.Nil(t, err)
+
+	storage, err := NewPostgresStorage(connStr)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	user, err := storage.CreateUser(ctx, server.User{Username: "Admin", PasswordHash: "hash", Admin: true})
+	assert.Nil(t, err)
+	assert.NotZero(t, user.ID)
+	assert.True(t, user.Admin)
+
+	_, err = storage.CreateUser(ctx, server.User{Username: "admin", PasswordHash: "hash"})
+	assert.ErrorIs(t, err, server.ErrConflict)
+
+	got, err := storage.GetUserByName(ctx, "ADMIN")
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID, got.ID)
+	assert.Equal(t, "hash", got.PasswordHash)
+
+	_, err = storage.GetUserByName(ctx, "nobody")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+
+	err = storage.CreateSession(ctx, user.ID, "expired", time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	_, err = storage.GetSessionUser(ctx, "expired")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+
+	err = storage.CreateSession(ctx, user.ID, "token", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	got, err = storage.GetSessionUser(ctx, "token")
+	assert.Nil(t, err)
+	assert.Equal(t, "Admin", got.Username)
+
+	err = storage.DeleteSession(ctx, "token")
+	assert.Nil(t, err)
+	_, err = storage.GetSessionUser(ctx, "token")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/comfyprog/allnews/server"
+	"github.com/lib/pq"
+)
+
+const userColumns = `users.id, users.username, users.password_hash, users.is_admin, users.created_at`
+
+func scanUser(row rowScanner) (server.User, error) {
+	var user server.User
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Admin, &user.CreatedAt)
+	return user, err
+}
+
+// CreateUser saves a new user, usernames are unique regardless of case
+func (s *PostgresStorage) CreateUser(ctx context.Context, user server.User) (server.User, error) {
+	created, err := scanUser(s.db.QueryRowContext(ctx, `
+INSERT INTO users (username, password_hash, is_admin) VALUES ($1, $2, $3)
+RETURNING `+userColumns, user.Username, user.PasswordHash, user.Admin))
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return created, fmt.Errorf("user %q already exists: %w", user.Username, server.ErrConflict)
+	}
+	return created, err
+}
+
+// GetUserByName returns user with given name, compared case-insensitively
+func (s *PostgresStorage) GetUserByName(ctx context.Context, username string) (server.User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE lower(username) = lower($1)`, username))
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("user %q: %w", username, server.ErrNotFound)
+	}
+	return user, err
+}
+
+// CreateSession saves a login session of the user, expired sessions are removed at the same time
+func (s *PostgresStorage) CreateSession(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= now()`); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`, tokenHash, userID, expiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSessionUser returns the user logged in with a session that hasn't expired yet
+func (s *PostgresStorage) GetSessionUser(ctx context.Context, tokenHash string) (server.User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx, `
+SELECT `+userColumns+` FROM sessions JOIN users ON users.id = sessions.user_id
+WHERE sessions.token_hash = $1 AND sessions.expires_at > now()`, tokenHash))
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("session: %w", server.ErrNotFound)
+	}
+	return user, err
+}
+
+func (s *PostgresStorage) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/comfyprog/allnews/feed"
+)
+
+// GetSubscription returns WebSub subscription of the source, or feed.ErrNoSubscription if there's none
+func (s *PostgresStorage) GetSubscription(ctx context.Context, source string) (feed.Subscription, error) {
+	sub := feed.Subscription{Source: source}
+	var leaseSeconds int64
+	var expiresAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, `
+SELECT hub, topic, secret, state, lease_seconds, expires_at, requested_at, error
+FROM websub_subscriptions WHERE source_name = $1`, source).
+		Scan(&sub.Hub, &sub.Topic, &sub.Secret, &sub.State, &leaseSeconds, &expiresAt, &sub.RequestedAt, &sub.Error)
+	if err == sql.ErrNoRows {
+		return sub, fmt.Errorf("source %q: %w", source, feed.ErrNoSubscription)
+	}
+	if err != nil {
+		return sub, err
+	}
+
+	sub.Lease = time.Duration(leaseSeconds) * time.Second
+	if expiresAt.Valid {
+		sub.ExpiresAt = &expiresAt.Time
+	}
+	return sub, nil
+}
+
+func (s *PostgresStorage) SaveSubscription(ctx context.Context, sub feed.Subscription) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO websub_subscriptions (source_name, hub, topic, secret, state, lease_seconds, expires_at, requested_at, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (source_name) DO UPDATE SET
+    hub = EXCLUDED.hub,
+    topic = EXCLUDED.topic,
+    secret = EXCLUDED.secret,
+    state = EXCLUDED.state,
+    lease_seconds = EXCLUDED.lease_seconds,
+    expires_at = EXCLUDED.expires_at,
+    requested_at = EXCLUDED.requested_at,
+    error = EXCLUDED.error`,
+		sub.Source, sub.Hub, sub.Topic, sub.Secret, sub.State, int64(sub.Lease.Seconds()), sub.ExpiresAt, sub.RequestedAt, sub.Error)
+	return err
+}
+
+func (s *PostgresStorage) DeleteSubscription(ctx context.Context, source string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM websub_subscriptions WHERE source_name = $1`, source)
+	return err
+}